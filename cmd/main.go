@@ -5,14 +5,23 @@ import (
 	"errors"
 	"flag"
 	"github.com/hecomp/cars/docs"
+	"github.com/hecomp/cars/internal/telemetry/logging"
+	"github.com/hecomp/cars/internal/telemetry/metrics"
+	"github.com/hecomp/cars/internal/telemetry/tracing"
 	"github.com/hecomp/cars/pkg/app"
+	"github.com/hecomp/cars/pkg/health"
+	"github.com/hecomp/cars/pkg/idempotency"
 	"github.com/hecomp/cars/pkg/repository"
+	"github.com/hecomp/cars/pkg/search"
 	"github.com/hecomp/cars/pkg/services"
-	"log"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/exp/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 )
 
@@ -20,6 +29,14 @@ var (
 	version = "1.0.0"
 )
 
+const (
+	// readTimeout bounds how long a request may take to send its headers
+	// and its body.
+	readTimeout = 5 * time.Second
+	// writeTimeout bounds how long a request may take to be answered.
+	writeTimeout = 10 * time.Second
+)
+
 // @title			GetCars CarsService
 // @description	This is a Goland server that manages cars.
 // @version		1.0.0
@@ -31,6 +48,22 @@ var (
 func main() {
 
 	var httpAddr = flag.String("http.addr", "localhost:9000", "Address for HTTP (JSON) server")
+	var adminAddr = flag.String("admin.addr", "localhost:9001", "Address for the admin endpoints, kept off the public network (empty disables)")
+	var dbBackend = flag.String("db.backend", "memory", "Storage backend for cars: memory or sqlite")
+	var dbPath = flag.String("db.path", "cars.db", "Path of the SQLite database file (sqlite backend only)")
+	var walDir = flag.String("db.wal", "", "Directory for the write-ahead log and snapshots (memory backend only, empty disables)")
+	var compactEvery = flag.Int("db.compact.every", 1000, "Number of log records written before compacting into a snapshot")
+	var purgeRetention = flag.Duration("purge.retention", 30*24*time.Hour, "How long archived cars are kept before being purged")
+	var purgeInterval = flag.Duration("purge.interval", time.Hour, "How often archived cars are checked for purging")
+	var idempotencyTTL = flag.Duration("idempotency.ttl", 24*time.Hour, "How long responses to requests with an Idempotency-Key are kept for replay")
+	var idempotencyLease = flag.Duration("idempotency.lease", time.Minute, "How long a request that never completes holds its Idempotency-Key")
+	var traceExporter = flag.String("trace.exporter", tracing.ExporterNone, "Where OpenTelemetry spans are exported: none, stderr or file")
+	var traceFile = flag.String("trace.file", "traces.jsonl", "File spans are appended to as OTLP JSON (file exporter only)")
+	var logLevel = new(slog.LevelVar)
+	flag.TextVar(logLevel, "log.level", logLevel, "Minimum level of the records logged: debug, info, warn or error")
+	var healthTimeout = flag.Duration("health.timeout", time.Second, "How long each readiness check may take before it fails")
+	var diskMin = flag.Uint64("health.disk.min", 64<<20, "Free bytes below which the disk holding the data is not ready")
+	var drainDelay = flag.Duration("shutdown.drain", 5*time.Second, "How long readiness fails before the server stops accepting requests on shutdown")
 
 	flag.Parse()
 
@@ -42,21 +75,90 @@ func main() {
 	docs.SwaggerInfo.BasePath = "/"
 	docs.SwaggerInfo.Schemes = []string{"http", "https"}
 
-	logger := log.New(os.Stdout, "cars ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logLevel).With("service", "cars", "version", version)
+	fatal := func(msg string, err error) {
+		logger.Error(msg, "error", err)
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := tracing.Setup(*traceExporter, *traceFile, version)
+	if err != nil {
+		fatal("Error setting up tracing", err)
+	}
+	defer shutdownTracing(context.Background())
 
-	r := repository.NewRepository()
-	s := services.NewCarsService(r)
+	var (
+		r      repository.Repository
+		store  idempotency.Store
+		checks = health.NewRegistry()
+	)
+	switch *dbBackend {
+	case "memory":
+		opts := []repository.Option{repository.WithCompactEvery(*compactEvery)}
+		if *walDir != "" {
+			opts = append(opts, repository.WithLog(*walDir))
+			checks.Register("log", *healthTimeout, health.Writable(*walDir))
+			checks.Register("disk", *healthTimeout, health.DiskSpace(*walDir, *diskMin))
+		}
+		r, err = repository.NewRepository(opts...)
+		if err != nil {
+			fatal("Error opening write-ahead log", err)
+		}
+		store = idempotency.NewMemoryStore()
+		if *walDir != "" {
+			// keep replays for as long as the cars they created. Keys are
+			// not in the write-ahead log, so a crash between a write and
+			// storing its response loses the response.
+			store, err = idempotency.NewSQLiteStore(filepath.Join(*walDir, "idempotency.db"))
+			if err != nil {
+				fatal("Error opening idempotency store", err)
+			}
+		}
+	case "sqlite":
+		r, err = repository.NewSQLiteRepository(*dbPath)
+		if err != nil {
+			fatal("Error opening database", err)
+		}
+		store, err = idempotency.NewSQLiteStore(*dbPath)
+		if err != nil {
+			fatal("Error opening idempotency store", err)
+		}
+		checks.Register("disk", *healthTimeout, health.DiskSpace(filepath.Dir(*dbPath), *diskMin))
+	default:
+		logger.Error("Unknown storage backend", "backend", *dbBackend)
+		os.Exit(1)
+	}
+	defer r.Close()
+	checks.Register("repository", *healthTimeout, r.Ping)
+	r = repository.Traced(r)
+	defer store.Close()
+
+	index, err := search.Build(context.Background(), r)
+	if err != nil {
+		fatal("Error building search index", err)
+	}
+	prometheus.MustRegister(metrics.NewInventoryCollector(r))
+
+	s := services.Traced(services.NewCarsService(r, index))
 	h := app.NewHandler(logger, s)
-	route := app.NewRoute(h)
+	route := app.NewRoute(h, app.NewIdempotency(store, *idempotencyTTL, *idempotencyLease, logger), app.NewHealth(checks, logger))
+
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	go services.RunPurger(purgeCtx, s, *purgeRetention, *purgeInterval, logger)
+	go idempotency.RunJanitor(purgeCtx, store, *idempotencyTTL, *purgeInterval, logger)
 
 	ctx := context.Background()
 	srv := &http.Server{
-		Handler:      route,
-		Addr:         *httpAddr,
-		ErrorLog:     logger,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		// Read and write deadlines are set per request, so exports, imports
+		// and streams can run past them as long as the client keeps up.
+		Handler: app.Deadlines(app.Trace(app.Log(app.Instrument(route, route.Pattern), logger, route.Pattern), route.Pattern),
+			readTimeout, writeTimeout),
+		Addr:              *httpAddr,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		ReadHeaderTimeout: readTimeout,
+		IdleTimeout:       120 * time.Second,
+		ConnContext:       app.WithConn,
 		BaseContext: func(l net.Listener) context.Context {
 			ctx = context.WithValue(ctx, httpAddr, l.Addr().String())
 			return ctx
@@ -65,28 +167,57 @@ func main() {
 
 	// start the server
 	go func() {
-		logger.Println("Starting server on port 9000")
+		logger.Info("Starting server", "addr", *httpAddr)
 
 		err := srv.ListenAndServe()
 		if errors.Is(err, http.ErrServerClosed) {
-			logger.Printf("server closed")
+			logger.Info("Server closed")
 		} else if err != nil {
-			logger.Printf("Error starting server: %s\n", err)
-			os.Exit(1)
+			fatal("Error starting server", err)
 		}
 	}()
 
+	var adminSrv *http.Server
+	if *adminAddr != "" {
+		admin := app.NewAdminRoute(app.NewLogLevel(logLevel, logger))
+		adminSrv = &http.Server{
+			Handler:           app.Deadlines(app.Log(admin, logger, admin.Pattern), readTimeout, writeTimeout),
+			Addr:              *adminAddr,
+			ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			ReadHeaderTimeout: readTimeout,
+			ConnContext:       app.WithConn,
+		}
+		go func() {
+			logger.Info("Starting admin server", "addr", *adminAddr)
+
+			err := adminSrv.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("Error starting admin server", err)
+			}
+		}()
+	}
+
 	// trap sigterm or interupt and gracefully shutdown the server
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	signal.Notify(c, os.Kill)
 
 	// Block until a signal is received.
 	sig := <-c
-	log.Println("Got signal:", sig)
+	logger.Info("Got signal", "signal", sig.String())
+
+	// fail readiness first, so load balancers stop sending requests before
+	// the server stops accepting them
+	checks.Drain()
+	logger.Info("Draining", "delay", drainDelay.String())
+	time.Sleep(*drainDelay)
 
 	// gracefully shutdown the server, waiting max 30 seconds for current operations to complete
-	ctx, _ = context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 	srv.Shutdown(ctx)
+	if adminSrv != nil {
+		adminSrv.Shutdown(ctx)
+	}
 
 }
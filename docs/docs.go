@@ -21,15 +21,688 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/car/{id}": {
+        "/admin/log-level": {
             "get": {
-                "description": "Reads a single car and returns it.",
+                "description": "Returns the minimum level of the records the service logs. Served on the admin listener, -admin.addr, not on the API's.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Get log level",
+                "operationId": "get-log-level",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.LogLevel"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Changes the minimum level of the records the service logs, until it is changed again or the service restarts. Served on the admin listener, -admin.addr, not on the API's.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Set log level",
+                "operationId": "set-log-level",
+                "parameters": [
+                    {
+                        "description": "debug, info, warn or error",
+                        "name": "level",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.LogLevel"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.LogLevel"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/livez": {
+            "get": {
+                "description": "Answers UP for as long as the process serves requests, without checking its dependencies, so a failing database does not get the service restarted.",
+                "produces": [
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "Health Check"
+                ],
+                "summary": "Liveness probe",
+                "operationId": "liveness",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.HealthResponse"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Runs every registered dependency check and reports each of them. Answers 503 when any check fails, or while the service is shutting down so load balancers drain it first.",
+                "produces": [
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "Health Check"
+                ],
+                "summary": "Readiness probe",
+                "operationId": "readiness",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.HealthResponse"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/models.HealthResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/cars": {
+            "get": {
+                "description": "Reads and returns a page of cars, optionally filtered and sorted. Follow the next link for the following page. With stream, or when NDJSON is accepted, every matching car is streamed instead, as it is read, in JSON, NDJSON or CSV.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack",
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "read"
+                ],
+                "summary": "GetCar all cars",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Set to archived to include soft deleted cars",
+                        "name": "include",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by make",
+                        "name": "make",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by model",
+                        "name": "model",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by color",
+                        "name": "color",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by category",
+                        "name": "category",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Filter by year",
+                        "name": "year",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Minimum price",
+                        "name": "minPrice",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum price",
+                        "name": "maxPrice",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Minimum mileage",
+                        "name": "minMileage",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum mileage",
+                        "name": "maxMileage",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated sort fields, prefixed with - for descending, e.g. -price,year",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 100 by default and at most 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor from the next link of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Stream every matching car instead of a page",
+                        "name": "stream",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/constants.UserResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Creates a new car.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "write"
+                ],
+                "summary": "Creates car",
+                "parameters": [
+                    {
+                        "description": "New car",
+                        "name": "car",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.Car"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key making retries replay the first response",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/constants.UserResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/cars/batch": {
+            "post": {
+                "description": "Creates, updates and deletes many cars in one request. In all-or-nothing mode, the default, the first failing operation rolls the whole batch back and is reported as the error. In best-effort mode every operation is attempted and the response lists the status, and error, of each; it is 207 when some failed. In dry-run mode the operations run like in best-effort mode but nothing is written.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "write"
+                ],
+                "summary": "Batch writes",
+                "parameters": [
+                    {
+                        "description": "Operations",
+                        "name": "batch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key making retries replay the first response",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/constants.UserResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/constants.BatchResult"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "207": {
+                        "description": "Multi-Status",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/constants.UserResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/constants.BatchResult"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/cars/export": {
+            "get": {
+                "description": "Streams every car, in id order, as CSV or newline delimited JSON. The format is taken from format, or else from the Accept header, and defaults to CSV.",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "read"
+                ],
+                "summary": "Export cars",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "csv or ndjson",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to archived to include soft deleted cars",
+                        "name": "include",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/cars/import": {
+            "post": {
+                "description": "Reads cars from a CSV or newline delimited JSON upload, creating the rows without an id and updating those with one, pinned to their version when set. Columns, or keys, are car fields unless renamed by map. Every row is checked and written on its own, and the rows that failed are reported by line; the response is 207 when some did. A dry run reports what the import would do without writing anything.",
+                "consumes": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "write"
+                ],
+                "summary": "Import cars",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "csv or ndjson, overriding Content-Type",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Column to field renames, e.g. Brand:make,Colour:color,Notes:-",
+                        "name": "map",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Check the rows without writing them",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/constants.UserResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/constants.ImportReport"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "207": {
+                        "description": "Multi-Status",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/constants.UserResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/constants.ImportReport"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/cars/vin/{vin}": {
+            "get": {
+                "description": "Reads and returns the car holding a VIN.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "read"
+                ],
+                "summary": "Get a car by VIN",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Vehicle identification number",
+                        "name": "vin",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to archived to include soft deleted cars",
+                        "name": "include",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/constants.UserResponse"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Version of the car"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/cars/{id}": {
+            "get": {
+                "description": "Reads a single car and returns it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack"
+                ],
                 "tags": [
                     "read"
                 ],
@@ -37,10 +710,96 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Car ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Car ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to archived to include soft deleted cars",
+                        "name": "include",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached copy of the car",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/constants.UserResponse"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Version of the car"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Updates a new car. When If-Match is given the update only applies if the car is still at that version.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "write"
+                ],
+                "summary": "Update car",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Car ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New car",
+                        "name": "car",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.Car"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the update is based on",
+                        "name": "If-Match",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -48,144 +807,302 @@ const docTemplate = `{
                         "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/constants.UserResponse"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "New version of the car"
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/constants.ErrorResponse"
+                            "$ref": "#/definitions/constants.Problem"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
                         "schema": {
-                            "$ref": "#/definitions/constants.ErrorResponse"
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
                         }
                     }
                 }
-            }
-        },
-        "/cars": {
-            "get": {
-                "description": "Reads and returns all the cars.",
+            },
+            "delete": {
+                "description": "Archives a single car so it can later be restored, or removes it for good when permanent is set.\nBreaking change: DELETE used to remove the car for good; it now archives it unless permanent=true is sent.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack"
                 ],
                 "tags": [
-                    "read"
+                    "write"
+                ],
+                "summary": "Delete car",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Car ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Delete permanently instead of archiving",
+                        "name": "permanent",
+                        "in": "query"
+                    }
                 ],
-                "summary": "GetCar all cars",
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/constants.UserResponse"
+                            "$ref": "#/definitions/constants.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/constants.ErrorResponse"
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
                         }
                     }
                 }
-            }
-        },
-        "/create": {
-            "post": {
-                "description": "Creates a new car.",
+            },
+            "patch": {
+                "description": "Changes some fields of a car with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902), which may include test operations. The id, version, createdAt and deletedAt fields are read only. When If-Match is given the patch only applies if the car is still at that version.",
                 "consumes": [
-                    "application/json"
+                    "application/merge-patch+json",
+                    "application/json-patch+json"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack"
                 ],
                 "tags": [
                     "write"
                 ],
-                "summary": "Creates car",
+                "summary": "Patch car",
                 "parameters": [
                     {
-                        "description": "New car",
-                        "name": "car",
+                        "type": "string",
+                        "description": "Car ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Patch document",
+                        "name": "patch",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.Car"
+                            "type": "object"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the patch is based on",
+                        "name": "If-Match",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/constants.UserResponse"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "New version of the car"
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/constants.ErrorResponse"
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/constants.ErrorResponse"
+                            "$ref": "#/definitions/constants.Problem"
                         }
                     }
                 }
             }
         },
-        "/health": {
-            "get": {
-                "description": "This endpoint will return a status to determine if the service is live or requires a restart",
+        "/v1/cars/{id}/restore": {
+            "post": {
+                "description": "Restores an archived car.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack"
                 ],
                 "tags": [
-                    "Health Check"
+                    "write"
+                ],
+                "summary": "Restore car",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Car ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "The liveness endpoint determines the LIVE status of the service",
-                "operationId": "liveliness",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.HealthResponse"
+                            "$ref": "#/definitions/constants.UserResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
                         }
                     }
                 }
             }
         },
-        "/update": {
-            "put": {
-                "description": "Updates a new car.",
+        "/v1/search": {
+            "get": {
+                "description": "Full-text search over make, model, package, color, category and year. Terms match as prefixes and tolerate typos; results are ordered by relevance.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "text/xml",
+                    "application/msgpack",
+                    "text/csv",
+                    "application/x-ndjson"
                 ],
                 "tags": [
-                    "write"
+                    "read"
                 ],
-                "summary": "Update car",
+                "summary": "Search cars",
                 "parameters": [
                     {
-                        "description": "New car",
-                        "name": "car",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/models.Car"
-                        }
+                        "type": "string",
+                        "description": "Search terms, e.g. red honda civic sport 2019",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of results, 20 by default",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -198,13 +1115,25 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/constants.ErrorResponse"
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/constants.Problem"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/constants.ErrorResponse"
+                            "$ref": "#/definitions/constants.Problem"
                         }
                     }
                 }
@@ -212,10 +1141,25 @@ const docTemplate = `{
         }
     },
     "definitions": {
-        "constants.ErrorResponse": {
+        "constants.BatchResult": {
+            "type": "object",
+            "properties": {
+                "car": {},
+                "error": {
+                    "$ref": "#/definitions/constants.Problem"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "integer"
+                }
+            }
+        },
+        "constants.FieldError": {
             "type": "object",
             "properties": {
-                "err": {
+                "field": {
                     "type": "string"
                 },
                 "message": {
@@ -223,12 +1167,120 @@ const docTemplate = `{
                 }
             }
         },
+        "constants.ImportError": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "$ref": "#/definitions/constants.Problem"
+                },
+                "line": {
+                    "type": "integer"
+                }
+            }
+        },
+        "constants.ImportReport": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "dryRun": {
+                    "type": "boolean"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/constants.ImportError"
+                    }
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "rows": {
+                    "type": "integer"
+                },
+                "updated": {
+                    "type": "integer"
+                }
+            }
+        },
+        "constants.Problem": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Code is a stable, machine-readable identifier of the kind of problem.",
+                    "type": "string"
+                },
+                "detail": {
+                    "description": "Detail explains this occurrence of the problem.",
+                    "type": "string"
+                },
+                "fields": {
+                    "description": "Fields lists the invalid fields of a rejected car.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/constants.FieldError"
+                    }
+                },
+                "instance": {
+                    "description": "Instance is the request URI the problem occurred on.",
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "title": {
+                    "description": "Title is a short summary of the kind of problem.",
+                    "type": "string"
+                },
+                "type": {
+                    "description": "Type is a URI identifying the kind of problem.",
+                    "type": "string"
+                }
+            }
+        },
         "constants.UserResponse": {
             "type": "object",
             "properties": {
                 "data": {},
                 "message": {
                     "type": "string"
+                },
+                "next": {
+                    "description": "Next links to the following page of a paginated listing.",
+                    "type": "string"
+                }
+            }
+        },
+        "models.BatchOperation": {
+            "type": "object",
+            "properties": {
+                "car": {
+                    "$ref": "#/definitions/models.Car"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "op": {
+                    "type": "string"
+                },
+                "permanent": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "models.BatchRequest": {
+            "type": "object",
+            "properties": {
+                "mode": {
+                    "description": "Mode is \"all-or-nothing\", the default, or \"best-effort\".",
+                    "type": "string"
+                },
+                "operations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.BatchOperation"
+                    }
                 }
             }
         },
@@ -241,6 +1293,14 @@ const docTemplate = `{
                 "color": {
                     "type": "string"
                 },
+                "createdAt": {
+                    "description": "CreatedAt is when the car was listed. It is unset for cars stored\nbefore listing times were recorded.",
+                    "type": "string"
+                },
+                "deletedAt": {
+                    "description": "DeletedAt is set when the car has been archived. Archived cars are\nhidden from reads unless explicitly requested and can be restored\nuntil they are purged.",
+                    "type": "string"
+                },
                 "id": {
                     "type": "string"
                 },
@@ -259,18 +1319,57 @@ const docTemplate = `{
                 "price": {
                     "type": "integer"
                 },
+                "version": {
+                    "description": "Version is incremented on every change to the car and backs the ETag\nused for optimistic concurrency control.",
+                    "type": "integer"
+                },
+                "vin": {
+                    "description": "VIN is the 17 character vehicle identification number. It is optional\nbut unique among the stored cars.",
+                    "type": "string"
+                },
                 "year": {
                     "type": "integer"
                 }
             }
         },
+        "models.HealthCheck": {
+            "type": "object",
+            "properties": {
+                "durationMs": {
+                    "type": "number"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
         "models.HealthResponse": {
             "type": "object",
             "properties": {
+                "checks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.HealthCheck"
+                    }
+                },
                 "status": {
                     "type": "string"
                 }
             }
+        },
+        "models.LogLevel": {
+            "type": "object",
+            "properties": {
+                "level": {
+                    "type": "string"
+                }
+            }
         }
     }
 }`
@@ -1,15 +1,64 @@
 package constants
 
+import "encoding/xml"
+
 // UserResponse represents a the response body of a Cars request
 // Success response
 // swagger:response ok
 type UserResponse struct {
-	Message string      `json:"message,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
+	XMLName xml.Name    `json:"-" xml:"response"`
+	Message string      `json:"message,omitempty" xml:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty" xml:"data,omitempty"`
+	// Next links to the following page of a paginated listing.
+	Next string `json:"next,omitempty" xml:"next,omitempty"`
+}
+
+// Problem is the RFC 7807 problem details body sent, as
+// application/problem+json, with every error response.
+type Problem struct {
+	// Type is a URI identifying the kind of problem.
+	Type string `json:"type" xml:"type"`
+	// Title is a short summary of the kind of problem.
+	Title  string `json:"title" xml:"title"`
+	Status int    `json:"status" xml:"status"`
+	// Detail explains this occurrence of the problem.
+	Detail string `json:"detail,omitempty" xml:"detail,omitempty"`
+	// Instance is the request URI the problem occurred on.
+	Instance string `json:"instance,omitempty" xml:"instance,omitempty"`
+	// Code is a stable, machine-readable identifier of the kind of problem.
+	Code string `json:"code" xml:"code"`
+	// Fields lists the invalid fields of a rejected car.
+	Fields []FieldError `json:"fields,omitempty" xml:"fields>field,omitempty"`
+}
+
+// FieldError describes why a single field of a request body is invalid.
+type FieldError struct {
+	Field   string `json:"field" xml:"field"`
+	Message string `json:"message" xml:"message"`
+}
+
+// BatchResult is the outcome of one operation of a batch, in the order the
+// operations were sent.
+type BatchResult struct {
+	Index  int         `json:"index" xml:"index"`
+	Status int         `json:"status" xml:"status"`
+	Car    interface{} `json:"car,omitempty" xml:"car,omitempty"`
+	Error  *Problem    `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+// ImportReport sums up an import. In a dry run the counts are what the
+// import would have done.
+type ImportReport struct {
+	DryRun  bool          `json:"dryRun" xml:"dryRun"`
+	Rows    int           `json:"rows" xml:"rows"`
+	Created int           `json:"created" xml:"created"`
+	Updated int           `json:"updated" xml:"updated"`
+	Failed  int           `json:"failed" xml:"failed"`
+	Errors  []ImportError `json:"errors,omitempty" xml:"errors>error,omitempty"`
 }
 
-// ErrorResponse is the response models sent on errors
-type ErrorResponse struct {
-	Message string `json:"message,omitempty"`
-	Err     string `json:"err,omitempty"`
+// ImportError is a row of an import that failed, by the line it starts on.
+type ImportError struct {
+	Line  int     `json:"line" xml:"line"`
+	Error Problem `json:"error" xml:"error"`
 }
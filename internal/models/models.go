@@ -1,20 +1,95 @@
 package models
 
+import (
+	"encoding/xml"
+	"time"
+)
+
 // Car represents a Car part of a Car Request
 // swagger:model
 type Car struct {
-	Id       string `json:"id"`
-	Make     string `json:"make"`
-	Model    string `json:"model"`
-	Package  string `json:"package"`
-	Color    string `json:"color"`
-	Year     int    `json:"year"`
-	Category string `json:"Category"`
-	Mileage  int    `json:"mileage"`
-	Price    int    `json:"price"`
-}
-
-// HealthResponse contains the current status of the application instance.
+	Id       string `json:"id" xml:"id"`
+	Make     string `json:"make" xml:"make"`
+	Model    string `json:"model" xml:"model"`
+	Package  string `json:"package" xml:"package"`
+	Color    string `json:"color" xml:"color"`
+	Year     int    `json:"year" xml:"year"`
+	Category string `json:"Category" xml:"Category"`
+	Mileage  int    `json:"mileage" xml:"mileage"`
+	Price    int    `json:"price" xml:"price"`
+	// VIN is the 17 character vehicle identification number. It is optional
+	// but unique among the stored cars.
+	VIN string `json:"vin,omitempty" xml:"vin,omitempty"`
+	// Version is incremented on every change to the car and backs the ETag
+	// used for optimistic concurrency control.
+	Version int64 `json:"version" xml:"version"`
+	// CreatedAt is when the car was listed. It is unset for cars stored
+	// before listing times were recorded.
+	CreatedAt *time.Time `json:"createdAt,omitempty" xml:"createdAt,omitempty"`
+	// DeletedAt is set when the car has been archived. Archived cars are
+	// hidden from reads unless explicitly requested and can be restored
+	// until they are purged.
+	DeletedAt *time.Time `json:"deletedAt,omitempty" xml:"deletedAt,omitempty"`
+}
+
+// Archived reports whether the car has been soft deleted.
+func (c *Car) Archived() bool {
+	return c.DeletedAt != nil
+}
+
+// Clone returns a copy of the car that shares nothing with it, or nil for a
+// nil car.
+func (c *Car) Clone() *Car {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	if c.CreatedAt != nil {
+		createdAt := *c.CreatedAt
+		clone.CreatedAt = &createdAt
+	}
+	if c.DeletedAt != nil {
+		deletedAt := *c.DeletedAt
+		clone.DeletedAt = &deletedAt
+	}
+	return &clone
+}
+
+// BatchRequest is the body of a batch of writes.
+type BatchRequest struct {
+	// Mode is "all-or-nothing", the default, or "best-effort".
+	Mode       string           `json:"mode,omitempty"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation is a single write of a batch. Op is "create" or "update",
+// which take Car, or "delete", which takes Id and archives the car unless
+// Permanent is set.
+type BatchOperation struct {
+	Op        string `json:"op"`
+	Car       *Car   `json:"car,omitempty"`
+	Id        string `json:"id,omitempty"`
+	Permanent bool   `json:"permanent,omitempty"`
+}
+
+// HealthResponse contains the current status of the application instance,
+// and the result of every check it took when readiness was asked for.
 type HealthResponse struct {
-	Status string `json:"status"`
+	XMLName xml.Name      `json:"-" xml:"health"`
+	Status  string        `json:"status" xml:"status"`
+	Checks  []HealthCheck `json:"checks,omitempty" xml:"checks>check,omitempty"`
+}
+
+// HealthCheck is the result of checking one dependency of the service.
+type HealthCheck struct {
+	Name       string  `json:"name" xml:"name"`
+	Status     string  `json:"status" xml:"status"`
+	DurationMs float64 `json:"durationMs" xml:"durationMs"`
+	Error      string  `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+// LogLevel is the minimum level of the records the service logs.
+type LogLevel struct {
+	XMLName xml.Name `json:"-" xml:"logLevel"`
+	Level   string   `json:"level" xml:"level"`
 }
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"context"
+	"github.com/hecomp/cars/pkg/repository"
+	"github.com/hecomp/cars/pkg/vin"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inventoryScrapeTimeout bounds how long a scrape may spend reading the
+// inventory.
+const inventoryScrapeTimeout = 10 * time.Second
+
+// otherMake labels the cars of makes outside the WMI table. Makes are free
+// text, so labelling by them as sent would let clients create series at will.
+const otherMake = "other"
+
+// mileageBuckets are the upper bounds of the mileage histogram.
+var mileageBuckets = []float64{10000, 25000, 50000, 75000, 100000, 150000, 200000}
+
+// inventoryCollector reports the live cars of a repository, computed afresh
+// on every scrape.
+type inventoryCollector struct {
+	repo repository.Repository
+
+	cars         *prometheus.Desc
+	priceTotal   *prometheus.Desc
+	priceAverage *prometheus.Desc
+	mileage      *prometheus.Desc
+	oldestAge    *prometheus.Desc
+}
+
+// NewInventoryCollector returns a collector of the inventory of repo. Cars
+// are read through an iterator, so a scrape holds no lock writers wait on
+// for longer than a page of cars takes to read. Register it next to the HTTP
+// metrics with prometheus.MustRegister.
+func NewInventoryCollector(repo repository.Repository) prometheus.Collector {
+	return inventoryCollector{
+		repo: repo,
+		cars: prometheus.NewDesc("cars_inventory_cars",
+			"The number of live cars by make, category and decade of their model year. Makes outside the WMI table are labelled other.",
+			[]string{"make", "category", "decade"}, nil),
+		priceTotal: prometheus.NewDesc("cars_inventory_price_total",
+			"The sum of the listed prices of the live cars.", nil, nil),
+		priceAverage: prometheus.NewDesc("cars_inventory_price_average",
+			"The average listed price of the live cars.", nil, nil),
+		mileage: prometheus.NewDesc("cars_inventory_mileage",
+			"The distribution of the mileage of the live cars.", nil, nil),
+		oldestAge: prometheus.NewDesc("cars_inventory_oldest_listing_age_seconds",
+			"The time since the longest listed live car was listed.", nil, nil),
+	}
+}
+
+func (c inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cars
+	ch <- c.priceTotal
+	ch <- c.priceAverage
+	ch <- c.mileage
+	ch <- c.oldestAge
+}
+
+type inventoryGroup struct {
+	make, category, decade string
+}
+
+func (c inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), inventoryScrapeTimeout)
+	defer cancel()
+
+	it, err := c.repo.Iterate(ctx, repository.Query{})
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.cars, err)
+		return
+	}
+	defer it.Close()
+
+	var (
+		groups     = make(map[inventoryGroup]int)
+		mileages   = make(map[float64]uint64, len(mileageBuckets))
+		count      uint64
+		priceSum   float64
+		mileageSum float64
+		oldest     time.Time
+	)
+	for it.Next() {
+		car := it.Car()
+		groups[inventoryGroup{
+			make:     makeLabel(car.Make),
+			category: strings.ToLower(car.Category),
+			decade:   decade(car.Year),
+		}]++
+		count++
+		priceSum += float64(car.Price)
+		mileageSum += float64(car.Mileage)
+		for _, bound := range mileageBuckets {
+			if float64(car.Mileage) <= bound {
+				mileages[bound]++
+			}
+		}
+		if car.CreatedAt != nil && (oldest.IsZero() || car.CreatedAt.Before(oldest)) {
+			oldest = *car.CreatedAt
+		}
+	}
+	if err = it.Err(); err != nil {
+		ch <- prometheus.NewInvalidMetric(c.cars, err)
+		return
+	}
+
+	for g, n := range groups {
+		ch <- prometheus.MustNewConstMetric(c.cars, prometheus.GaugeValue, float64(n), g.make, g.category, g.decade)
+	}
+	ch <- prometheus.MustNewConstMetric(c.priceTotal, prometheus.GaugeValue, priceSum)
+	if count > 0 {
+		ch <- prometheus.MustNewConstMetric(c.priceAverage, prometheus.GaugeValue, priceSum/float64(count))
+	}
+	ch <- prometheus.MustNewConstHistogram(c.mileage, count, mileageSum, mileages)
+	if !oldest.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.oldestAge, prometheus.GaugeValue, time.Since(oldest).Seconds())
+	}
+}
+
+// makeLabel returns the label of make, otherMake unless it is known.
+func makeLabel(make string) string {
+	if !vin.KnownMake(make) {
+		return otherMake
+	}
+	return strings.ToLower(strings.TrimSpace(make))
+}
+
+// decade buckets a model year by its decade, such as 2010s.
+func decade(year int) string {
+	if year <= 0 {
+		return "unknown"
+	}
+	return strconv.Itoa(year/10*10) + "s"
+}
@@ -0,0 +1,20 @@
+package metrics
+
+import "testing"
+
+func TestMakeLabel(t *testing.T) {
+	tests := []struct {
+		make, want string
+	}{
+		{"Ford", "ford"},
+		{" ACURA ", "acura"},
+		{"Fordd", otherMake},
+		{"", otherMake},
+		{"x9f3k2-unique-per-request", otherMake},
+	}
+	for _, tt := range tests {
+		if got := makeLabel(tt.make); got != tt.want {
+			t.Errorf("makeLabel(%q) = %q, want %q", tt.make, got, tt.want)
+		}
+	}
+}
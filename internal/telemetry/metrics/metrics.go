@@ -5,29 +5,26 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// The HTTP metrics are labelled by route template, such as /v1/cars/{id},
+// method and status class, such as 2xx, never by anything taken from the
+// request itself, so the number of series stays bounded.
 var (
-	BadRequestCount = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "http_bad_request_count",
-		Help: "The total number of bad request",
-	}, []string{"endpoint", "car"})
-	NotFoundCount = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "http_not_found_request_count",
-		Help: "The total number of not found request",
-	}, []string{"endpoint", "car"})
-	UnmarshalFailCount = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "http_unmarshal_fail_request_count",
-		Help: "The total number of unmarshal fail request",
-	}, []string{"endpoint", "car"})
-	CreateFailCount = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "http_create_create_request_count",
-		Help: "The total number of unmarshal fail request",
-	}, []string{"endpoint", "car"})
-	UpdateFailCount = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "http_update_fail_request_count",
-		Help: "The total number of unmarshal fail request",
-	}, []string{"endpoint", "car"})
+	RequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "The total number of HTTP requests served.",
+	}, []string{"route", "method", "status"})
 	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name: "myapp_processed_ops_total",
-		Help: "The total number of processed events",
-	}, []string{"endpoint", "status", "car"})
+		Name:    "http_request_duration_seconds",
+		Help:    "The time taken to serve HTTP requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	RequestsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "The number of HTTP requests being served.",
+	}, []string{"route", "method"})
+	ResponseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_response_size_bytes",
+		Help:    "The size of HTTP response bodies.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"route", "method", "status"})
 )
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"sync"
+)
+
+// fileClient is an OTLP client that writes every export request as a line
+// of OTLP JSON, the format the OpenTelemetry collector reads back from files,
+// so traces can be kept without a collector running.
+//
+// OTLP JSON differs from the canonical JSON of its protobuf messages in that
+// trace and span ids are hex rather than base64 and enums are numbers, so the
+// output of protojson is adjusted before it is written.
+type fileClient struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func newFileClient(w io.Writer, closer io.Closer) *fileClient {
+	return &fileClient{w: w, closer: closer}
+}
+
+func (c *fileClient) Start(context.Context) error {
+	return nil
+}
+
+func (c *fileClient) Stop(context.Context) error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+func (c *fileClient) UploadTraces(_ context.Context, spans []*tracepb.ResourceSpans) error {
+	data, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(
+		&coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	hexIDs(doc)
+	if data, err = json.Marshal(doc); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, err = c.w.Write(append(data, '\n'))
+	return err
+}
+
+// idFields are the fields of OTLP JSON holding trace or span ids.
+var idFields = map[string]bool{"traceId": true, "spanId": true, "parentSpanId": true}
+
+// hexIDs rewrites the base64 ids found anywhere in doc as hex.
+func hexIDs(doc interface{}) {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if s, ok := value.(string); ok && idFields[key] {
+				if id, err := base64.StdEncoding.DecodeString(s); err == nil {
+					v[key] = hex.EncodeToString(id)
+				}
+				continue
+			}
+			hexIDs(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			hexIDs(value)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+)
+
+// The exporters spans can be sent to.
+const (
+	// ExporterNone drops every span. Trace context is still propagated.
+	ExporterNone = "none"
+	// ExporterStderr writes spans to standard error as OTLP JSON, keeping
+	// them apart from the logs on standard output.
+	ExporterStderr = "stderr"
+	// ExporterFile appends spans to a file as OTLP JSON.
+	ExporterFile = "file"
+)
+
+// Setup installs the global tracer provider, exporting spans of the cars
+// service at version through exporter, and the W3C trace context
+// propagator. The file exporter appends to path. The returned function
+// flushes the spans not exported yet and stops the exporter.
+func Setup(exporter, path, version string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var client otlptrace.Client
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStderr:
+		client = newFileClient(os.Stderr, nil)
+	case ExporterFile:
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open trace file %v: %w", path, err)
+		}
+		client = newFileClient(f, f)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+
+	exp, err := otlptrace.New(context.Background(), client)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName("cars"),
+			semconv.ServiceVersion(version))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// End records err, when there is one, on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+var ErrLogLevel = repository.NewError(repository.KindValidation, "invalid_log_level", "level must be one of debug, info, warn or error")
+
+// LogLevel lets operators read and change the level of logger at runtime,
+// through level, without restarting the service.
+type LogLevel struct {
+	level  *slog.LevelVar
+	logger *slog.Logger
+}
+
+func NewLogLevel(level *slog.LevelVar, logger *slog.Logger) *LogLevel {
+	return &LogLevel{level: level, logger: logger}
+}
+
+// GetLogLevel godoc
+//
+//	@summary		Get log level
+//	@description	Returns the minimum level of the records the service logs. Served on the admin listener, -admin.addr, not on the API's.
+//	@tags			Admin
+//	@id				get-log-level
+//	@produce		json
+//	@success		200	{object}	models.LogLevel
+//	@router			/admin/log-level [get]
+func (l *LogLevel) GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	l.write(w)
+}
+
+// SetLogLevel godoc
+//
+//	@summary		Set log level
+//	@description	Changes the minimum level of the records the service logs, until it is changed again or the service restarts. Served on the admin listener, -admin.addr, not on the API's.
+//	@tags			Admin
+//	@id				set-log-level
+//	@accept			json
+//	@produce		json
+//	@param			level	body		models.LogLevel	true	"debug, info, warn or error"
+//	@success		200		{object}	models.LogLevel
+//	@failure		400		{object}	constants.Problem
+//	@failure		422		{object}	constants.Problem
+//	@router			/admin/log-level [put]
+func (l *LogLevel) SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req models.LogLevel
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		renderProblem(w, r, fmt.Errorf("%w: %v", ErrMalformedBody, err))
+		return
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		renderProblem(w, r, fmt.Errorf("%w: %q", ErrLogLevel, req.Level))
+		return
+	}
+	from := l.level.Level()
+	l.level.Set(level)
+	l.logger.WarnCtx(r.Context(), "Changed log level", "from", from, "to", level)
+	l.write(w)
+}
+
+func (l *LogLevel) write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.LogLevel{Level: l.level.Level().String()})
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hecomp/cars/internal/constants"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"net/http"
+)
+
+// MaxBatchSize is the largest number of operations accepted in one batch.
+const MaxBatchSize = 10000
+
+var (
+	ErrBatchMode     = repository.NewError(repository.KindInvalid, "invalid_batch_mode", "mode must be all-or-nothing, best-effort or dry-run")
+	ErrBatchTooLarge = repository.NewError(repository.KindInvalid, "batch_too_large", fmt.Sprintf("a batch holds at most %d operations", MaxBatchSize))
+)
+
+var batchModes = map[string]repository.BatchMode{
+	"":               repository.AllOrNothing,
+	"all-or-nothing": repository.AllOrNothing,
+	"best-effort":    repository.BestEffort,
+	"dry-run":        repository.DryRun,
+}
+
+// BatchCars godoc
+//
+//	@Summary	Batch writes
+//	@Schemes
+//	@Description	Creates, updates and deletes many cars in one request. In all-or-nothing mode, the default, the first failing operation rolls the whole batch back and is reported as the error. In best-effort mode every operation is attempted and the response lists the status, and error, of each; it is 207 when some failed. In dry-run mode the operations run like in best-effort mode but nothing is written.
+//	@Tags			write
+//	@Accept			json
+//	@Produce		json,xml,application/msgpack
+//	@Param			batch			body		models.BatchRequest	true	"Operations"
+//	@Param			Idempotency-Key	header		string				false	"Key making retries replay the first response"
+//	@Success		200		{object}	constants.UserResponse{data=[]constants.BatchResult}
+//	@Success		207		{object}	constants.UserResponse{data=[]constants.BatchResult}
+//	@Failure		400		{object}	constants.Problem
+//	@Failure		404		{object}	constants.Problem
+//	@Failure		406		{object}	constants.Problem
+//	@Failure		409		{object}	constants.Problem
+//	@Failure		413		{object}	constants.Problem
+//	@Failure		422		{object}	constants.Problem
+//	@Failure		500		{object}	constants.Problem
+//	@Router			/v1/cars/batch [post]
+func (c *carsHandler) BatchCars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req models.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.writeProblem(w, r, fmt.Errorf("%w: %v", ErrMalformedBody, err))
+		return
+	}
+	mode, ok := batchModes[req.Mode]
+	if !ok {
+		c.writeProblem(w, r, fmt.Errorf("%w: got %q", ErrBatchMode, req.Mode))
+		return
+	}
+	if len(req.Operations) > MaxBatchSize {
+		c.writeProblem(w, r, fmt.Errorf("%w: got %d", ErrBatchTooLarge, len(req.Operations)))
+		return
+	}
+
+	ops := make([]repository.Op, len(req.Operations))
+	for i, op := range req.Operations {
+		ops[i] = repository.Op{Kind: repository.OpKind(op.Op), Car: op.Car, Id: op.Id, Permanent: op.Permanent}
+	}
+	results, err := c.services.Batch(r.Context(), ops, mode)
+	if err != nil {
+		// The problem only carries the message of the failing operation's
+		// error, so its position is added back.
+		p := problem(r, err)
+		for i, result := range results {
+			if result.Err != nil && result.Err != repository.ErrNotApplied {
+				p.Detail = fmt.Sprintf("operation %d: %v", i, p.Detail)
+				break
+			}
+		}
+		c.reportProblem(w, r, p, err)
+		return
+	}
+
+	status := http.StatusOK
+	out := make([]constants.BatchResult, len(results))
+	for i, result := range results {
+		out[i] = constants.BatchResult{Index: i, Status: opStatus(ops[i])}
+		if result.Err != nil {
+			p := problem(r, result.Err)
+			out[i].Status, out[i].Error = p.Status, &p
+			status = http.StatusMultiStatus
+			continue
+		}
+		if result.Car != nil {
+			out[i].Car = result.Car
+		}
+	}
+	c.render(w, r, status, &constants.UserResponse{
+		Data: out,
+	})
+}
+
+// opStatus is the status a successful op would have had as a single request.
+func opStatus(op repository.Op) int {
+	switch {
+	case op.Kind == repository.OpCreate:
+		return http.StatusCreated
+	case op.Kind == repository.OpDelete && op.Permanent:
+		return http.StatusNoContent
+	default:
+		return http.StatusOK
+	}
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+type (
+	connKey      struct{}
+	deadlinesKey struct{}
+)
+
+// WithConn stores the connection c in ctx. Set it as the ConnContext of the
+// http.Server serving Deadlines, which needs it to reach the connection of
+// each request.
+func WithConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// deadlines are the read and write deadlines of the connection of a request.
+type deadlines struct {
+	conn        net.Conn
+	read, write time.Duration
+	rolling     bool
+}
+
+// Deadlines gives every request next serves read long to send its body and
+// write long to be answered, as the ReadTimeout and WriteTimeout of an
+// http.Server would, except that handlers moving more data than fits in them
+// can push them back as they make progress: exports, imports and streamed
+// listings are cut off when the client stalls, not when they run long.
+//
+// The server must have WithConn as its ConnContext and no ReadTimeout or
+// WriteTimeout of its own.
+func Deadlines(next http.Handler, read, write time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ok := r.Context().Value(connKey{}).(net.Conn)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d := &deadlines{conn: conn, read: read, write: write}
+		conn.SetWriteDeadline(time.Now().Add(write))
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &deadlineBody{ReadCloser: r.Body, d: d, until: time.Now().Add(read)}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), deadlinesKey{}, d)))
+	})
+}
+
+// extendWriteDeadline gives the response to r another write timeout from
+// now. Handlers writing a long response call it each time they send a part.
+func extendWriteDeadline(r *http.Request) {
+	if d, ok := r.Context().Value(deadlinesKey{}).(*deadlines); ok {
+		d.conn.SetWriteDeadline(time.Now().Add(d.write))
+	}
+}
+
+// extendReadDeadline lets the body of r take as long as it needs, as long as
+// no read of it waits for longer than the read timeout.
+func extendReadDeadline(r *http.Request) {
+	if d, ok := r.Context().Value(deadlinesKey{}).(*deadlines); ok {
+		d.rolling = true
+	}
+}
+
+// deadlineBody holds reads of a request body to the read deadline, and lifts
+// it once the body has been read, so that it doesn't cut the connection's
+// reads once the server waits for the next request or watches for the
+// client going away.
+type deadlineBody struct {
+	io.ReadCloser
+	d     *deadlines
+	until time.Time
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	until := b.until
+	if b.d.rolling {
+		until = time.Now().Add(b.d.read)
+	}
+	b.d.conn.SetReadDeadline(until)
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.d.conn.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
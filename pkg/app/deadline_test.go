@@ -0,0 +1,172 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hecomp/cars/internal/constants"
+	"github.com/hecomp/cars/pkg/repository"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	testReadTimeout  = 250 * time.Millisecond
+	testWriteTimeout = 250 * time.Millisecond
+)
+
+// slowRepository pauses for delay before each page of cars it iterates over
+// after the first, as a large repository would.
+type slowRepository struct {
+	repository.Repository
+	delay time.Duration
+}
+
+func (r slowRepository) Iterate(ctx context.Context, q repository.Query) (repository.Iterator, error) {
+	it, err := r.Repository.Iterate(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return &slowIterator{Iterator: it, delay: r.delay}, nil
+}
+
+type slowIterator struct {
+	repository.Iterator
+	delay time.Duration
+	n     int
+}
+
+func (it *slowIterator) Next() bool {
+	if it.n > 0 && it.n%streamFlushEvery == 0 {
+		time.Sleep(it.delay)
+	}
+	it.n++
+	return it.Iterator.Next()
+}
+
+// newDeadlineServer serves the API over repo as the service does, with the
+// test read and write timeouts.
+func newDeadlineServer(t *testing.T, repo repository.Repository) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(Deadlines(newTestRoute(t, repo, nil), testReadTimeout, testWriteTimeout))
+	srv.Config.ConnContext = WithConn
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fillRepository(t *testing.T, cars int) repository.Repository {
+	t.Helper()
+	repo, err := repository.NewRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < cars; n++ {
+		if _, err = repo.Save(context.Background(), testCar(0, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+// TestStreamsOutliveWriteDeadline streams listings and exports that take
+// several write timeouts to produce. Those that keep producing must arrive
+// whole, and those that stall for longer than a write timeout must be cut
+// off so that the client notices.
+func TestStreamsOutliveWriteDeadline(t *testing.T) {
+	const cars = 4 * streamFlushEvery
+	tests := []struct {
+		name      string
+		path      string
+		accept    string
+		wantLines int
+	}{
+		{"export", "/v1/cars/export?format=ndjson", "", cars},
+		{"csv export", "/v1/cars/export", "text/csv", cars + 1},
+		{"ndjson listing", "/v1/cars", "application/x-ndjson", cars},
+		{"csv listing", "/v1/cars?stream=true", "text/csv", cars + 1},
+	}
+	repo := fillRepository(t, cars)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, stalled := range []bool{false, true} {
+				delay := testWriteTimeout / 2
+				if stalled {
+					delay = 2 * testWriteTimeout
+				}
+				srv := newDeadlineServer(t, slowRepository{Repository: repo, delay: delay})
+
+				req, err := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.accept != "" {
+					req.Header.Set("Accept", tt.accept)
+				}
+				start := time.Now()
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines := 0
+				scanner := bufio.NewScanner(resp.Body)
+				for scanner.Scan() {
+					lines++
+				}
+				resp.Body.Close()
+
+				switch {
+				case !stalled && (scanner.Err() != nil || lines != tt.wantLines):
+					t.Errorf("got %d lines in %v, want %d: %v", lines, time.Since(start), tt.wantLines, scanner.Err())
+				case !stalled && time.Since(start) <= testWriteTimeout:
+					t.Errorf("took %v, not past the write timeout", time.Since(start))
+				case stalled && scanner.Err() == nil:
+					t.Errorf("stalled stream ended cleanly after %d lines", lines)
+				}
+			}
+		})
+	}
+}
+
+// TestImportOutlivesReadDeadline uploads rows slowly, for longer than the
+// read timeout but never pausing for as long.
+func TestImportOutlivesReadDeadline(t *testing.T) {
+	const rows = 5
+	srv := newDeadlineServer(t, fillRepository(t, 0))
+
+	body, upload := io.Pipe()
+	go func() {
+		fmt.Fprintln(upload, "make,model,package,color,category,year,price,mileage")
+		for n := 0; n < rows; n++ {
+			time.Sleep(testReadTimeout / 2)
+			car := testCar(1, n)
+			fmt.Fprintf(upload, "%v,%v,%v,%v,%v,%d,%d,%d\n",
+				car.Make, car.Model, car.Package, car.Color, car.Category, car.Year, car.Price, car.Mileage)
+		}
+		upload.Close()
+	}()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/cars/import", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/csv")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Data constants.ImportReport `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || out.Data.Created != rows {
+		t.Errorf("import = %d, %+v, want %d rows created", resp.StatusCode, out.Data, rows)
+	}
+}
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"strings"
+)
+
+// etag returns the strong entity tag identifying the current version of car.
+func etag(car *models.Car) string {
+	return fmt.Sprintf(`"%d"`, car.Version)
+}
+
+// matchesETag reports whether header, an If-Match or If-None-Match value,
+// lists tag or is the "*" wildcard. Weak tags only match when weak is true,
+// as If-Match requires the strong comparison and If-None-Match the weak one.
+func matchesETag(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
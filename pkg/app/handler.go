@@ -6,46 +6,67 @@ import (
 	"fmt"
 	"github.com/hecomp/cars/internal/constants"
 	"github.com/hecomp/cars/internal/models"
-	"github.com/hecomp/cars/internal/telemetry/metrics"
+	"github.com/hecomp/cars/pkg/repository"
 	"github.com/hecomp/cars/pkg/services"
+	"golang.org/x/exp/slog"
 	"io"
-	"log"
+	"mime"
 	"net/http"
-	"strconv"
 	"strings"
-	"time"
 )
 
 var (
-	ErrEmpty       = errors.New("empty id")
-	ErrCarBody     = errors.New("car %s is invalid")
-	ErrCreateCar   = errors.New("error creating car")
-	ErrUpdateCar   = errors.New("error updating car")
-	ErrNoData      = errors.New("no data")
-	ErrCarNotFound = errors.New("car not found")
-
-	CarCreatedSuccess = fmt.Sprintf("car created successfully!")
-	CarUpdatedSuccess = fmt.Sprintf("car updated successfully!")
+	ErrEmpty         = repository.NewError(repository.KindInvalid, "empty_id", "empty id")
+	ErrEmptyVIN      = repository.NewError(repository.KindInvalid, "empty_vin", "empty vin")
+	ErrIdMismatch    = repository.NewError(repository.KindInvalid, "id_mismatch", "car id in body does not match the path")
+	ErrMalformedBody = repository.NewError(repository.KindInvalid, "malformed_body", "malformed request body")
+	ErrInvalidParam  = repository.NewError(repository.KindInvalid, "invalid_parameter", "invalid query parameter")
+	ErrEmptyQuery    = repository.NewError(repository.KindInvalid, "empty_query", "empty search query")
+	ErrPatchType     = repository.NewError(repository.KindInvalid, "unsupported_patch_type", "unsupported patch media type")
+	ErrPrecondition  = repository.NewError(repository.KindPrecondition, "precondition_failed", "car has been modified since it was read")
+	ErrNoData        = repository.NewError(repository.KindNotFound, "no_data", "no data")
+
+	CarCreatedSuccess   = fmt.Sprintf("car created successfully!")
+	CarUpdatedSuccess   = fmt.Sprintf("car updated successfully!")
+	CarRestoredSuccess  = fmt.Sprintf("car restored successfully!")
+	CarsImportedSuccess = fmt.Sprintf("cars imported successfully!")
+	DryRunSuccess       = fmt.Sprintf("dry run completed, nothing was written")
 )
 
+const defaultSearchLimit = 20
+
 // CarsHandler defines all the handlers the CarsService needs.
 type CarsHandler interface {
 	GetCar(w http.ResponseWriter, r *http.Request)
+	GetCarByVIN(w http.ResponseWriter, r *http.Request)
 	GetCars(w http.ResponseWriter, r *http.Request)
 	CreateCar(w http.ResponseWriter, r *http.Request)
 	UpdateCar(w http.ResponseWriter, r *http.Request)
-	HealthHandler(w http.ResponseWriter, r *http.Request)
+	PatchCar(w http.ResponseWriter, r *http.Request)
+	BatchCars(w http.ResponseWriter, r *http.Request)
+	ExportCars(w http.ResponseWriter, r *http.Request)
+	ImportCars(w http.ResponseWriter, r *http.Request)
+	DeleteCar(w http.ResponseWriter, r *http.Request)
+	RestoreCar(w http.ResponseWriter, r *http.Request)
+	SearchCars(w http.ResponseWriter, r *http.Request)
 }
 
 type carsHandler struct {
 	services services.CarsService
-	logger   *log.Logger
+	logger   *slog.Logger
 }
 
-func NewHandler(logger *log.Logger, svc services.CarsService) CarsHandler {
+func NewHandler(logger *slog.Logger, svc services.CarsService) CarsHandler {
 	return &carsHandler{services: svc, logger: logger}
 }
 
+// readOptions reads the ?include=archived opt-in shared by the read endpoints.
+func readOptions(r *http.Request) repository.ReadOptions {
+	return repository.ReadOptions{
+		IncludeArchived: r.URL.Query().Get("include") == "archived",
+	}
+}
+
 // GetCar godoc
 //
 //	@Summary	Get car
@@ -53,45 +74,74 @@ func NewHandler(logger *log.Logger, svc services.CarsService) CarsHandler {
 //	@Description	Reads a single car and returns it.
 //	@Tags			read
 //	@Accept			json
-//	@Produce		json
-//	@Param			id	path		string	true	"Car ID"
-//	@Success		200	{object}	constants.UserResponse
-//	@Failure		400	{object}	constants.ErrorResponse
-//	@Failure		404	{object}	constants.ErrorResponse
-//	@Router			/car/{id} [get]
+//	@Produce		json,xml,application/msgpack
+//	@Param			id				path		string	true	"Car ID"
+//	@Param			include			query		string	false	"Set to archived to include soft deleted cars"
+//	@Param			If-None-Match	header		string	false	"ETag of a cached copy of the car"
+//	@Success		200				{object}	constants.UserResponse
+//	@Header			200				{string}	ETag	"Version of the car"
+//	@Success		304
+//	@Failure		400	{object}	constants.Problem
+//	@Failure		404	{object}	constants.Problem
+//	@Failure		406	{object}	constants.Problem
+//	@Router			/v1/cars/{id} [get]
 func (c *carsHandler) GetCar(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Content-Type", "Application-Json")
 
-	endpoint := "/car"
-	start := time.Now()
-	id := strings.TrimPrefix(r.URL.Path, "/car/")
+	id := PathParam(r, "id")
 	if id == "" {
-		metrics.BadRequestCount.WithLabelValues(endpoint, id).Inc()
-		c.logger.Println(ErrEmpty)
-		w.WriteHeader(http.StatusBadRequest)
-		response := constants.ErrorResponse{
-			Err: ErrEmpty.Error(),
-		}
-		json.NewEncoder(w).Encode(response)
+		c.writeProblem(w, r, ErrEmpty)
 		return
 	}
 
-	car, uErr := c.services.GetCar(id)
+	car, uErr := c.services.GetCar(r.Context(), id, readOptions(r))
 	if uErr != nil {
-		metrics.NotFoundCount.WithLabelValues(endpoint, id).Inc()
-		c.logger.Println(ErrCarNotFound)
-		w.WriteHeader(http.StatusNotFound)
-		response := constants.ErrorResponse{
-			Err: uErr.Error(),
-		}
-		json.NewEncoder(w).Encode(response)
+		c.writeProblem(w, r, uErr)
+		return
+	}
+	tag := etag(car)
+	w.Header().Set("ETag", tag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && matchesETag(inm, tag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	c.render(w, r, http.StatusOK, &constants.UserResponse{
+		Data: car,
+	})
+}
+
+// GetCarByVIN godoc
+//
+//	@Summary	Get a car by VIN
+//	@Schemes
+//	@Description	Reads and returns the car holding a VIN.
+//	@Tags			read
+//	@Accept			json
+//	@Produce		json,xml,application/msgpack
+//	@Param			vin			path		string	true	"Vehicle identification number"
+//	@Param			include		query		string	false	"Set to archived to include soft deleted cars"
+//	@Success		200			{object}	constants.UserResponse
+//	@Header			200			{string}	ETag	"Version of the car"
+//	@Failure		400			{object}	constants.Problem
+//	@Failure		404			{object}	constants.Problem
+//	@Failure		406			{object}	constants.Problem
+//	@Router			/v1/cars/vin/{vin} [get]
+func (c *carsHandler) GetCarByVIN(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	vin := PathParam(r, "vin")
+	if vin == "" {
+		c.writeProblem(w, r, ErrEmptyVIN)
+		return
+	}
+
+	car, err := c.services.GetCarByVIN(r.Context(), vin, readOptions(r))
+	if err != nil {
+		c.writeProblem(w, r, err)
 		return
 	}
-	metrics.RequestDuration.WithLabelValues(endpoint, strconv.Itoa(http.StatusOK), car.Id).
-		Observe(time.Since(start).Seconds())
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(&constants.UserResponse{
+	w.Header().Set("ETag", etag(car))
+	c.render(w, r, http.StatusOK, &constants.UserResponse{
 		Data: car,
 	})
 }
@@ -100,34 +150,120 @@ func (c *carsHandler) GetCar(w http.ResponseWriter, r *http.Request) {
 //
 //	@Summary	GetCar all cars
 //	@Schemes
-//	@Description	Reads and returns all the cars.
+//	@Description	Reads and returns a page of cars, optionally filtered and sorted. Follow the next link for the following page. With stream, or when NDJSON is accepted, every matching car is streamed instead, as it is read, in JSON, NDJSON or CSV.
 //	@Tags			read
 //	@Accept			json
-//	@Produce		json
-//	@Success		200	{object}	constants.UserResponse
-//	@Failure		404	{object}	constants.ErrorResponse
-//	@Router			/cars [get]
+//	@Produce		json,xml,application/msgpack,text/csv,application/x-ndjson
+//	@Param			include		query		string	false	"Set to archived to include soft deleted cars"
+//	@Param			make		query		string	false	"Filter by make"
+//	@Param			model		query		string	false	"Filter by model"
+//	@Param			color		query		string	false	"Filter by color"
+//	@Param			category	query		string	false	"Filter by category"
+//	@Param			year		query		int		false	"Filter by year"
+//	@Param			minPrice	query		int		false	"Minimum price"
+//	@Param			maxPrice	query		int		false	"Maximum price"
+//	@Param			minMileage	query		int		false	"Minimum mileage"
+//	@Param			maxMileage	query		int		false	"Maximum mileage"
+//	@Param			sort		query		string	false	"Comma separated sort fields, prefixed with - for descending, e.g. -price,year"
+//	@Param			limit		query		int		false	"Page size, 100 by default and at most 1000"
+//	@Param			cursor		query		string	false	"Cursor from the next link of the previous page"
+//	@Param			stream		query		bool	false	"Stream every matching car instead of a page"
+//	@Success		200			{object}	constants.UserResponse
+//	@Failure		400			{object}	constants.Problem
+//	@Failure		404			{object}	constants.Problem
+//	@Failure		406			{object}	constants.Problem
+//	@Failure		500			{object}	constants.Problem
+//	@Router			/v1/cars [get]
 func (c *carsHandler) GetCars(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Content-Type", "Application-Json")
 
-	endpoint := "/cars"
-	start := time.Now()
-	cars := c.services.GetCars()
-	if len(cars) == 0 {
-		metrics.NotFoundCount.WithLabelValues(endpoint, "").Inc()
-		c.logger.Println(ErrNoData)
-		w.WriteHeader(http.StatusNotFound)
-		response := constants.ErrorResponse{
-			Message: ErrNoData.Error(),
+	query, err := parseQuery(r)
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	stream, err := boolParam(r.URL.Query(), "stream")
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	if rd := negotiated(r); stream || rd.ContentType == NDJSONRenderer.ContentType {
+		newStream, ok := streams[rd.ContentType]
+		if !ok {
+			c.writeProblem(w, r, fmt.Errorf("%w: %v can't be streamed", ErrNotAcceptable, rd.ContentType))
+			return
+		}
+		w.Header().Set("Content-Type", rd.ContentType)
+		err = c.streamCars(w, r, newStream, ErrNoData, func(fn func(*models.Car) error) error {
+			return c.services.EachCar(r.Context(), query, fn)
+		})
+		if err != nil {
+			c.writeProblem(w, r, err)
+			return
 		}
-		json.NewEncoder(w).Encode(response)
 		return
 	}
-	metrics.RequestDuration.WithLabelValues(endpoint, strconv.Itoa(http.StatusOK), "").
-		Observe(time.Since(start).Seconds())
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(&constants.UserResponse{
+
+	page, err := c.services.QueryCars(r.Context(), query)
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	if len(page.Cars) == 0 {
+		c.writeProblem(w, r, ErrNoData)
+		return
+	}
+	c.render(w, r, http.StatusOK, &constants.UserResponse{
+		Data: page.Cars,
+		Next: nextLink(r, page.Next),
+	})
+}
+
+// SearchCars godoc
+//
+//	@Summary	Search cars
+//	@Schemes
+//	@Description	Full-text search over make, model, package, color, category and year. Terms match as prefixes and tolerate typos; results are ordered by relevance.
+//	@Tags			read
+//	@Accept			json
+//	@Produce		json,xml,application/msgpack,text/csv,application/x-ndjson
+//	@Param			q		query		string	true	"Search terms, e.g. red honda civic sport 2019"
+//	@Param			limit	query		int		false	"Maximum number of results, 20 by default"
+//	@Success		200		{object}	constants.UserResponse
+//	@Failure		400		{object}	constants.Problem
+//	@Failure		404		{object}	constants.Problem
+//	@Failure		406		{object}	constants.Problem
+//	@Failure		500		{object}	constants.Problem
+//	@Router			/v1/search [get]
+func (c *carsHandler) SearchCars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	q := r.URL.Query().Get("q")
+	limit, err := intParam(r.URL.Query(), "limit")
+	if err == nil && strings.TrimSpace(q) == "" {
+		err = ErrEmptyQuery
+	}
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > repository.MaxLimit {
+		limit = repository.MaxLimit
+	}
+
+	cars, err := c.services.Search(r.Context(), q, limit)
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	if len(cars) == 0 {
+		c.writeProblem(w, r, ErrNoData)
+		return
+	}
+	c.render(w, r, http.StatusOK, &constants.UserResponse{
 		Data: cars,
 	})
 }
@@ -139,57 +275,37 @@ func (c *carsHandler) GetCars(w http.ResponseWriter, r *http.Request) {
 //	@Description	Creates a new car.
 //	@Tags			write
 //	@Accept			json
-//	@Produce		json
-//	@Param			car	body		models.Car	true	"New car"
+//	@Produce		json,xml,application/msgpack
+//	@Param			car				body		models.Car	true	"New car"
+//	@Param			Idempotency-Key	header		string		false	"Key making retries replay the first response"
 //	@Success		201	{object}	constants.UserResponse
-//	@Failure		400	{object}	constants.ErrorResponse
-//	@Failure		500	{object}	constants.ErrorResponse
-//	@Router			/create [post]
+//	@Failure		400	{object}	constants.Problem
+//	@Failure		406	{object}	constants.Problem
+//	@Failure		409	{object}	constants.Problem
+//	@Failure		422	{object}	constants.Problem
+//	@Failure		500	{object}	constants.Problem
+//	@Router			/v1/cars [post]
 func (c *carsHandler) CreateCar(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Content-Type", "Application-Json")
 
-	endpoint := "/create"
-	start := time.Now()
 	bytes, err := io.ReadAll(r.Body)
 	if err != nil {
-		metrics.BadRequestCount.WithLabelValues(endpoint, "").Inc()
-		c.logger.Println(ErrCarBody)
-		w.WriteHeader(http.StatusBadRequest)
-		response := constants.ErrorResponse{
-			Err: ErrCarBody.Error(),
-		}
-		json.NewEncoder(w).Encode(response)
+		c.writeProblem(w, r, fmt.Errorf("%w: %v", ErrMalformedBody, err))
 		return
 	}
 	var car models.Car
 	err = json.Unmarshal(bytes, &car)
 	if err != nil {
-		metrics.UnmarshalFailCount.WithLabelValues(endpoint, car.Id).Inc()
-		c.logger.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		response := constants.ErrorResponse{
-			Err: err.Error(),
-		}
-		json.NewEncoder(w).Encode(response)
+		c.writeProblem(w, r, fmt.Errorf("%w: %v", ErrMalformedBody, err))
 		return
 	}
 
-	if _, err = c.services.Create(&car); err != nil {
-		metrics.CreateFailCount.WithLabelValues(endpoint, car.Id).Inc()
-		c.logger.Println(ErrCreateCar)
-		w.WriteHeader(http.StatusInternalServerError)
-		response := constants.ErrorResponse{
-			Message: ErrCreateCar.Error(),
-			Err:     err.Error(),
-		}
-		json.NewEncoder(w).Encode(response)
+	if _, err = c.services.Create(r.Context(), &car); err != nil {
+		c.writeProblem(w, r, err)
 		return
 	}
-	metrics.RequestDuration.WithLabelValues(endpoint, strconv.Itoa(http.StatusOK), car.Id).
-		Observe(time.Since(start).Seconds())
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(&constants.UserResponse{
+	w.Header().Set("ETag", etag(&car))
+	c.render(w, r, http.StatusCreated, &constants.UserResponse{
 		Message: CarCreatedSuccess,
 		Data:    car,
 	})
@@ -199,82 +315,227 @@ func (c *carsHandler) CreateCar(w http.ResponseWriter, r *http.Request) {
 //
 //	@Summary	Update car
 //	@Schemes
-//	@Description	Updates a new car.
+//	@Description	Updates a new car. When If-Match is given the update only applies if the car is still at that version.
 //	@Tags			write
 //	@Accept			json
-//	@Produce		json
-//	@Param			car	body		models.Car	true	"New car"
-//	@Success		200	{object}	constants.UserResponse
-//	@Failure		400	{object}	constants.ErrorResponse
-//	@Failure		500	{object}	constants.ErrorResponse
-//	@Router			/update [put]
+//	@Produce		json,xml,application/msgpack
+//	@Param			id			path		string		true	"Car ID"
+//	@Param			car			body		models.Car	true	"New car"
+//	@Param			If-Match	header		string		false	"ETag the update is based on"
+//	@Success		200			{object}	constants.UserResponse
+//	@Header			200			{string}	ETag	"New version of the car"
+//	@Failure		400			{object}	constants.Problem
+//	@Failure		406			{object}	constants.Problem
+//	@Failure		409			{object}	constants.Problem
+//	@Failure		412			{object}	constants.Problem
+//	@Failure		422			{object}	constants.Problem
+//	@Failure		500			{object}	constants.Problem
+//	@Router			/v1/cars/{id} [put]
 func (c *carsHandler) UpdateCar(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Content-Type", "Application-Json")
 
-	endpoint := "/update"
-	start := time.Now()
 	bytes, err := io.ReadAll(r.Body)
 	if err != nil {
-		metrics.BadRequestCount.WithLabelValues(endpoint, "").Inc()
-		c.logger.Println(ErrCarBody)
-		w.WriteHeader(http.StatusBadRequest)
-		response := constants.ErrorResponse{
-			Err: ErrCarBody.Error(),
-		}
-		json.NewEncoder(w).Encode(response)
+		c.writeProblem(w, r, fmt.Errorf("%w: %v", ErrMalformedBody, err))
 		return
 	}
 	var car models.Car
 	err = json.Unmarshal(bytes, &car)
 	if err != nil {
-		metrics.UnmarshalFailCount.WithLabelValues(endpoint, car.Id).Inc()
-		c.logger.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		response := constants.ErrorResponse{
-			Err: err.Error(),
-		}
-		json.NewEncoder(w).Encode(response)
+		c.writeProblem(w, r, fmt.Errorf("%w: %v", ErrMalformedBody, err))
 		return
 	}
 
-	if _, err = c.services.Update(&car); err != nil {
-		metrics.UpdateFailCount.WithLabelValues(endpoint, car.Id).Inc()
-		c.logger.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		response := constants.ErrorResponse{
-			Message: ErrUpdateCar.Error(),
-			Err:     err.Error(),
+	// On /v1/cars/{id} the path names the car; a body naming another one is
+	// rejected rather than silently retargeted.
+	if id := PathParam(r, "id"); id != "" {
+		if car.Id != "" && car.Id != id {
+			c.writeProblem(w, r, ErrIdMismatch)
+			return
 		}
-		json.NewEncoder(w).Encode(response)
+		car.Id = id
+	}
+
+	// The version is managed by the server; clients pin it with If-Match.
+	if car.Version, err = c.ifMatch(r, car.Id); err != nil {
+		c.writeProblem(w, r, err)
 		return
 	}
-	metrics.RequestDuration.WithLabelValues(endpoint, strconv.Itoa(http.StatusOK), car.Id).
-		Observe(time.Since(start).Seconds())
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(&constants.UserResponse{
+
+	if _, err = c.services.Update(r.Context(), &car); err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(&car))
+	c.render(w, r, http.StatusOK, &constants.UserResponse{
 		Message: CarUpdatedSuccess,
 		Data:    car,
 	})
 }
 
-// HealthHandler check liveness check
+// ifMatch returns the version of car id that the If-Match header of r pins
+// the change to, or 0 without one. A header matching neither the current
+// version nor a car at all, as when it was deleted, fails the precondition.
+func (c *carsHandler) ifMatch(r *http.Request, id string) (int64, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, nil
+	}
+	current, err := c.services.GetCar(r.Context(), id, repository.ReadOptions{})
+	if errors.Is(err, repository.ErrNotFound) {
+		return 0, fmt.Errorf("%w: car %v does not exist", ErrPrecondition, id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !matchesETag(header, etag(current), false) {
+		return 0, ErrPrecondition
+	}
+	return current.Version, nil
+}
+
+// PatchCar godoc
 //
-//	@summary		The liveness endpoint determines the LIVE status of the service
-//	@description	This endpoint will return a status to determine if the service is live or requires a restart
-//	@tags			Health Check
-//	@id				liveliness
-//	@accept			json
-//	@produce		json
-//	@success		200	{object}	models.HealthResponse
-//	@router			/health [get]
-func (c *carsHandler) HealthHandler(w http.ResponseWriter, r *http.Request) {
+//	@Summary	Patch car
+//	@Schemes
+//	@Description	Changes some fields of a car with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902), which may include test operations. The id, version, createdAt and deletedAt fields are read only. When If-Match is given the patch only applies if the car is still at that version.
+//	@Tags			write
+//	@Accept			application/merge-patch+json
+//	@Accept			application/json-patch+json
+//	@Produce		json,xml,application/msgpack
+//	@Param			id			path		string	true	"Car ID"
+//	@Param			patch		body		object	true	"Patch document"
+//	@Param			If-Match	header		string	false	"ETag the patch is based on"
+//	@Success		200			{object}	constants.UserResponse
+//	@Header			200			{string}	ETag	"New version of the car"
+//	@Failure		400			{object}	constants.Problem
+//	@Failure		404			{object}	constants.Problem
+//	@Failure		406			{object}	constants.Problem
+//	@Failure		409			{object}	constants.Problem
+//	@Failure		412			{object}	constants.Problem
+//	@Failure		415			{object}	constants.Problem
+//	@Failure		422			{object}	constants.Problem
+//	@Failure		500			{object}	constants.Problem
+//	@Router			/v1/cars/{id} [patch]
+func (c *carsHandler) PatchCar(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Content-Type", "Application-Json")
-	c.logger.Println("Checking application health")
-	w.WriteHeader(http.StatusOK)
-	response := &models.HealthResponse{
-		Status: "UP",
+
+	id := PathParam(r, "id")
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		c.writeProblem(w, r, fmt.Errorf("%w: %v", ErrMalformedBody, err))
+		return
+	}
+
+	var patch services.Patch
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case services.MergePatchType:
+		patch, err = services.NewMergePatch(bytes)
+	case services.JSONPatchType:
+		patch, err = services.NewJSONPatch(bytes)
+	default:
+		w.Header().Set("Accept-Patch", services.MergePatchType+", "+services.JSONPatchType)
+		c.writeProblem(w, r, ErrPatchType)
+		return
+	}
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+
+	version, err := c.ifMatch(r, id)
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+
+	car, err := c.services.Patch(r.Context(), id, version, patch)
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
 	}
-	json.NewEncoder(w).Encode(response)
+	w.Header().Set("ETag", etag(car))
+	c.render(w, r, http.StatusOK, &constants.UserResponse{
+		Message: CarUpdatedSuccess,
+		Data:    car,
+	})
+}
+
+// DeleteCar godoc
+//
+//	@Summary	Delete car
+//	@Schemes
+//	@Description	Archives a single car so it can later be restored, or removes it for good when permanent is set.
+//	@Description	Breaking change: DELETE used to remove the car for good; it now archives it unless permanent=true is sent.
+//	@Tags			write
+//	@Accept			json
+//	@Produce		json,xml,application/msgpack
+//	@Param			id			path	string	true	"Car ID"
+//	@Param			permanent	query	bool	false	"Delete permanently instead of archiving"
+//	@Success		204
+//	@Failure		400	{object}	constants.Problem
+//	@Failure		404	{object}	constants.Problem
+//	@Failure		406	{object}	constants.Problem
+//	@Failure		500	{object}	constants.Problem
+//	@Router			/v1/cars/{id} [delete]
+func (c *carsHandler) DeleteCar(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id := PathParam(r, "id")
+	if id == "" {
+		c.writeProblem(w, r, ErrEmpty)
+		return
+	}
+
+	permanent, err := boolParam(r.URL.Query(), "permanent")
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	if permanent {
+		err = c.services.Delete(r.Context(), id)
+	} else {
+		_, err = c.services.Archive(r.Context(), id)
+	}
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreCar godoc
+//
+//	@Summary	Restore car
+//	@Schemes
+//	@Description	Restores an archived car.
+//	@Tags			write
+//	@Accept			json
+//	@Produce		json,xml,application/msgpack
+//	@Param			id	path		string	true	"Car ID"
+//	@Success		200	{object}	constants.UserResponse
+//	@Failure		400	{object}	constants.Problem
+//	@Failure		404	{object}	constants.Problem
+//	@Failure		406	{object}	constants.Problem
+//	@Failure		500	{object}	constants.Problem
+//	@Router			/v1/cars/{id}/restore [post]
+func (c *carsHandler) RestoreCar(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id := PathParam(r, "id")
+	if id == "" {
+		c.writeProblem(w, r, ErrEmpty)
+		return
+	}
+
+	car, err := c.services.Restore(r.Context(), id)
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	c.render(w, r, http.StatusOK, &constants.UserResponse{
+		Message: CarRestoredSuccess,
+		Data:    car,
+	})
 }
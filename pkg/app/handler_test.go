@@ -0,0 +1,295 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hecomp/cars/internal/constants"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/health"
+	"github.com/hecomp/cars/pkg/repository"
+	"github.com/hecomp/cars/pkg/search"
+	"github.com/hecomp/cars/pkg/services"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var discard = slog.New(slog.HandlerOptions{}.NewJSONHandler(io.Discard))
+
+// newTestServer serves the API over an in-memory repository, honouring
+// Idempotency-Key through idem unless it is nil.
+func newTestServer(t *testing.T, idem *Idempotency) *httptest.Server {
+	t.Helper()
+	repo, err := repository.NewRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newTestRoute(t, repo, idem))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestRoute maps the API onto repo.
+func newTestRoute(t *testing.T, repo repository.Repository, idem *Idempotency) *Router {
+	t.Helper()
+	index, err := search.Build(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRoute(NewHandler(discard, services.NewCarsService(repo, index)), idem,
+		NewHealth(health.NewRegistry(), discard))
+}
+
+// do sends a JSON request and decodes the car in the response, if any.
+func do(t *testing.T, method, url string, body interface{}) (int, *models.Car) {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var decoded struct {
+		Data *models.Car `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp.StatusCode, decoded.Data
+}
+
+func testCar(owner, n int) *models.Car {
+	return &models.Car{
+		Make:     "Ford",
+		Model:    fmt.Sprintf("M%d", owner),
+		Package:  "Base",
+		Color:    "Red",
+		Category: "Sedan",
+		Year:     2000 + n%20,
+		Price:    1000 + n,
+		Mileage:  n,
+	}
+}
+
+// TestConcurrentRequestsCannotCorruptCars has clients create, update, read
+// and list cars at the same time. Every car must end up as its owner last
+// wrote it, at the version its number of writes says.
+func TestConcurrentRequestsCannotCorruptCars(t *testing.T) {
+	const (
+		clients = 8
+		owned   = 5
+		rounds  = 10
+	)
+	srv := newTestServer(t, nil)
+
+	want := make([]map[string]*models.Car, clients)
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			mine := map[string]*models.Car{}
+			for n := 0; n < owned; n++ {
+				status, car := do(t, http.MethodPost, srv.URL+"/v1/cars", testCar(c, n))
+				if status != http.StatusCreated || car == nil {
+					t.Errorf("create: %d", status)
+					return
+				}
+				mine[car.Id] = car
+			}
+			for round := 0; round < rounds; round++ {
+				for id := range mine {
+					update := testCar(c, round)
+					if status, _ := do(t, http.MethodPut, srv.URL+"/v1/cars/"+id, update); status != http.StatusOK {
+						t.Errorf("update %v: %d", id, status)
+						return
+					}
+					update.Id, update.Version = id, int64(round+2)
+					mine[id] = update
+					if status, _ := do(t, http.MethodGet, srv.URL+"/v1/cars/"+id, nil); status != http.StatusOK {
+						t.Errorf("get %v: %d", id, status)
+					}
+				}
+				if status, _ := do(t, http.MethodGet, srv.URL+"/v1/cars?make=Ford&sort=-price&limit=20", nil); status != http.StatusOK {
+					t.Errorf("list: %d", status)
+				}
+			}
+			want[c] = mine
+		}(c)
+	}
+	wg.Wait()
+
+	for _, mine := range want {
+		for id, expected := range mine {
+			status, got := do(t, http.MethodGet, srv.URL+"/v1/cars/"+id, nil)
+			if status != http.StatusOK || got == nil {
+				t.Fatalf("get %v: %d", id, status)
+			}
+			if got.Model != expected.Model || got.Price != expected.Price || got.Mileage != expected.Mileage ||
+				got.Year != expected.Year || got.Version != expected.Version {
+				t.Errorf("car %v is %+v, want %+v", id, *got, *expected)
+			}
+		}
+	}
+}
+
+func TestDeletePermanent(t *testing.T) {
+	tests := []struct {
+		query        string
+		wantStatus   int
+		wantArchived bool
+	}{
+		{"", http.StatusNoContent, true},
+		{"?permanent=false", http.StatusNoContent, true},
+		{"?permanent=true", http.StatusNoContent, false},
+		{"?permanent=yes", http.StatusBadRequest, false},
+	}
+	srv := newTestServer(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, car := do(t, http.MethodPost, srv.URL+"/v1/cars", testCar(0, 1))
+			if status, _ := do(t, http.MethodDelete, srv.URL+"/v1/cars/"+car.Id+tt.query, nil); status != tt.wantStatus {
+				t.Fatalf("delete = %d, want %d", status, tt.wantStatus)
+			}
+			status, _ := do(t, http.MethodGet, srv.URL+"/v1/cars/"+car.Id+"?include=archived", nil)
+			switch {
+			case tt.wantStatus != http.StatusNoContent && status != http.StatusOK:
+				t.Errorf("car was deleted by a rejected request: %d", status)
+			case tt.wantStatus == http.StatusNoContent && tt.wantArchived != (status == http.StatusOK):
+				t.Errorf("get archived = %d, want archived: %v", status, tt.wantArchived)
+			}
+		})
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		missing    bool
+		ifMatch    string
+		wantStatus int
+	}{
+		{"current version", false, `"1"`, http.StatusOK},
+		{"any version", false, "*", http.StatusOK},
+		{"stale version", false, `"0", "2"`, http.StatusPreconditionFailed},
+		{"weak tag", false, `W/"1"`, http.StatusPreconditionFailed},
+		{"missing car", true, `"1"`, http.StatusPreconditionFailed},
+		{"missing car, any version", true, "*", http.StatusPreconditionFailed},
+		{"missing car, no precondition", true, "", http.StatusNotFound},
+	}
+	requests := []struct {
+		method, contentType, body string
+	}{
+		{http.MethodPut, "application/json", `{"make":"Ford","model":"Focus","package":"Base","color":"Red","category":"Sedan","year":2010,"price":1000,"mileage":0}`},
+		{http.MethodPatch, services.MergePatchType, `{"price":2000}`},
+	}
+	srv := newTestServer(t, nil)
+	for _, req := range requests {
+		for _, tt := range tests {
+			t.Run(req.method+" "+tt.name, func(t *testing.T) {
+				_, car := do(t, http.MethodPost, srv.URL+"/v1/cars", testCar(0, 1))
+				if tt.missing {
+					if status, _ := do(t, http.MethodDelete, srv.URL+"/v1/cars/"+car.Id+"?permanent=true", nil); status != http.StatusNoContent {
+						t.Fatalf("delete = %d", status)
+					}
+				}
+
+				r, err := http.NewRequest(req.method, srv.URL+"/v1/cars/"+car.Id, strings.NewReader(req.body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.Header.Set("Content-Type", req.contentType)
+				if tt.ifMatch != "" {
+					r.Header.Set("If-Match", tt.ifMatch)
+				}
+				resp, err := http.DefaultClient.Do(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != tt.wantStatus {
+					t.Errorf("%v = %d, want %d", req.method, resp.StatusCode, tt.wantStatus)
+				}
+			})
+		}
+	}
+}
+
+func TestCreateInvalidCar(t *testing.T) {
+	srv := newTestServer(t, nil)
+	car := testCar(0, 1)
+	car.Make, car.Price = "", -1
+	data, err := json.Marshal(car)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(srv.URL+"/v1/cars", "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var p constants.Problem
+	if err = json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	want := []constants.FieldError{
+		{Field: "make", Message: "is required"},
+		{Field: "price", Message: "must not be negative"},
+	}
+	if resp.StatusCode != http.StatusUnprocessableEntity || p.Code != services.ErrValidation.Code || !reflect.DeepEqual(p.Fields, want) {
+		t.Errorf("create = %d %+v, want 422 with fields %+v", resp.StatusCode, p, want)
+	}
+	if status, _ := do(t, http.MethodGet, srv.URL+"/v1/cars", nil); status != http.StatusNotFound {
+		t.Errorf("listing after a rejected create = %d, want no cars", status)
+	}
+}
+
+// TestBatchNamesFailingOperation checks that a rolled back batch is answered
+// with the problem of the operation that failed, naming its position.
+func TestBatchNamesFailingOperation(t *testing.T) {
+	srv := newTestServer(t, nil)
+	invalid := testCar(0, 2)
+	invalid.Price = -1
+	body, err := json.Marshal(map[string]interface{}{"operations": []map[string]interface{}{
+		{"op": "create", "car": testCar(0, 1)},
+		{"op": "create", "car": invalid},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(srv.URL+"/v1/cars/batch", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var p constants.Problem
+	if err = json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnprocessableEntity || !strings.HasPrefix(p.Detail, "operation 1: ") || len(p.Fields) != 1 {
+		t.Errorf("batch = %d %+v, want 422 naming operation 1", resp.StatusCode, p)
+	}
+	if status, _ := do(t, http.MethodGet, srv.URL+"/v1/cars", nil); status != http.StatusNotFound {
+		t.Errorf("listing after a rolled back batch = %d, want no cars", status)
+	}
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/health"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+// Health answers the probes of orchestrators and load balancers: liveness
+// tells whether the process should be restarted, readiness whether it should
+// be sent traffic.
+type Health struct {
+	checks *health.Registry
+	logger *slog.Logger
+}
+
+func NewHealth(checks *health.Registry, logger *slog.Logger) *Health {
+	return &Health{checks: checks, logger: logger}
+}
+
+// Livez godoc
+//
+//	@summary		Liveness probe
+//	@description	Answers UP for as long as the process serves requests, without checking its dependencies, so a failing database does not get the service restarted.
+//	@tags			Health Check
+//	@id				liveness
+//	@produce		json,xml,application/msgpack
+//	@success		200	{object}	models.HealthResponse
+//	@failure		406	{object}	constants.Problem
+//	@router			/livez [get]
+func (h *Health) Livez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	renderTo(w, r, h.logger, http.StatusOK, &models.HealthResponse{Status: health.StatusUp})
+}
+
+// Readyz godoc
+//
+//	@summary		Readiness probe
+//	@description	Runs every registered dependency check and reports each of them. Answers 503 when any check fails, or while the service is shutting down so load balancers drain it first.
+//	@tags			Health Check
+//	@id				readiness
+//	@produce		json,xml,application/msgpack
+//	@success		200	{object}	models.HealthResponse
+//	@failure		406	{object}	constants.Problem
+//	@failure		503	{object}	models.HealthResponse
+//	@router			/readyz [get]
+func (h *Health) Readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-store")
+	report := h.checks.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusUp {
+		status = http.StatusServiceUnavailable
+		for _, c := range report.Checks {
+			if c.Status != health.StatusUp {
+				h.logger.WarnCtx(r.Context(), "Health check failed", "check", c.Name, "error", c.Error)
+			}
+		}
+	}
+	renderTo(w, r, h.logger, status, report)
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hecomp/cars/pkg/idempotency"
+	"github.com/hecomp/cars/pkg/repository"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader carries the client's key for a retryable write.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// ReplayedHeader is set on responses replayed from an earlier request.
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKey = 255
+)
+
+var (
+	ErrIdempotencyKey      = repository.NewError(repository.KindInvalid, "invalid_idempotency_key", fmt.Sprintf("Idempotency-Key must be 1 to %d printable ASCII characters", maxIdempotencyKey))
+	ErrIdempotencyKeyReuse = repository.NewError(repository.KindValidation, "idempotency_key_reused", "Idempotency-Key was already used with a different request")
+	ErrIdempotencyInFlight = repository.NewError(repository.KindConflict, "idempotency_key_in_flight", "a request with this Idempotency-Key is still in progress")
+)
+
+// Idempotency makes writes safe to retry. The first response to a request
+// carrying an Idempotency-Key is stored for ttl and replayed byte for byte to
+// every repeat of that request, without running the handler again. A key is
+// held for at most lease by a request that never completes it, as one whose
+// server crashed.
+type Idempotency struct {
+	store  idempotency.Store
+	ttl    time.Duration
+	lease  time.Duration
+	logger *slog.Logger
+}
+
+func NewIdempotency(store idempotency.Store, ttl, lease time.Duration, logger *slog.Logger) *Idempotency {
+	return &Idempotency{store: store, ttl: ttl, lease: lease, logger: logger}
+}
+
+// Wrap returns h honouring Idempotency-Key. A key identifies one request to
+// operation, whichever path it was sent to. Requests without the header are
+// passed through untouched, as is everything when i is nil.
+func (i *Idempotency) Wrap(operation string, h http.HandlerFunc) http.HandlerFunc {
+	if i == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := r.Header[IdempotencyKeyHeader]
+		if !ok {
+			h(w, r)
+			return
+		}
+		if len(key) != 1 || !validIdempotencyKey(key[0]) {
+			renderProblem(w, r, ErrIdempotencyKey)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			renderProblem(w, r, fmt.Errorf("%w: %v", ErrMalformedBody, err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		fingerprint := idempotency.Fingerprint(operation, body)
+		rec, err := i.store.Reserve(key[0], fingerprint, now, now.Add(-i.ttl), now.Add(-i.lease))
+		switch {
+		case err != nil:
+			i.logger.ErrorCtx(r.Context(), "Error reserving idempotency key", "key", key[0], "error", err)
+			renderProblem(w, r, err)
+			return
+		case rec == nil:
+			i.record(w, r, key[0], fingerprint, now, h)
+		case rec.Fingerprint != fingerprint:
+			renderProblem(w, r, ErrIdempotencyKeyReuse)
+		case rec.Pending():
+			renderProblem(w, r, ErrIdempotencyInFlight)
+		default:
+			replay(w, rec)
+		}
+	}
+}
+
+// record runs h and stores its response under key. Server errors are not
+// stored, and neither is anything when h panics, so the key is released for
+// the client to retry. When h succeeded but its response can't be stored the
+// key stays reserved, as releasing it would let a retry write again; the
+// retry is answered as in flight until the lease runs out.
+func (i *Idempotency) record(w http.ResponseWriter, r *http.Request, key, fingerprint string, now time.Time, h http.HandlerFunc) {
+	rw := &recorder{ResponseWriter: w}
+	failed := true
+	defer func() {
+		if !failed {
+			return
+		}
+		if err := i.store.Release(key, now); err != nil {
+			i.logger.ErrorCtx(r.Context(), "Error releasing idempotency key", "key", key, "error", err)
+		}
+	}()
+
+	h(rw, r)
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if rw.status >= http.StatusInternalServerError {
+		return
+	}
+	failed = false
+	err := i.store.Complete(&idempotency.Record{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		Status:      rw.status,
+		Header:      rw.header,
+		Body:        rw.body.Bytes(),
+	})
+	if err != nil {
+		i.logger.ErrorCtx(r.Context(), "Error storing response for idempotency key", "key", key, "error", err)
+	}
+}
+
+func replay(w http.ResponseWriter, rec *idempotency.Record) {
+	for name, values := range rec.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
+
+func validIdempotencyKey(key string) bool {
+	return printable(key, maxIdempotencyKey)
+}
+
+// printable reports whether s is 1 to max printable ASCII characters.
+func printable(s string, max int) bool {
+	if s == "" || len(s) > max {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// ownHeaders belong to the response they were sent with, so they are not
+// replayed to the requests repeating it.
+var ownHeaders = []string{RequestIDHeader, "Traceparent"}
+
+// recorder copies what a handler writes so it can be stored.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rw *recorder) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+		rw.header = rw.Header().Clone()
+		for _, name := range ownHeaders {
+			rw.header.Del(name)
+		}
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/hecomp/cars/pkg/idempotency"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// failingStore fails to store any response.
+type failingStore struct {
+	idempotency.Store
+}
+
+func (failingStore) Complete(*idempotency.Record) error {
+	return errors.New("disk full")
+}
+
+func post(t *testing.T, url, key string, body interface{}) int {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IdempotencyKeyHeader, key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func countCars(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url + "/v1/cars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var listing struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		t.Fatal(err)
+	}
+	return len(listing.Data)
+}
+
+func TestRetryAfterFailedCompleteDoesNotWriteAgain(t *testing.T) {
+	idem := NewIdempotency(failingStore{idempotency.NewMemoryStore()}, time.Hour, time.Hour, discard)
+	srv := newTestServer(t, idem)
+
+	if status := post(t, srv.URL+"/v1/cars", "k", testCar(0, 1)); status != http.StatusCreated {
+		t.Fatalf("create = %d", status)
+	}
+	if status := post(t, srv.URL+"/v1/cars", "k", testCar(0, 1)); status != http.StatusConflict {
+		t.Errorf("retry = %d, want %d", status, http.StatusConflict)
+	}
+	if n := countCars(t, srv.URL); n != 1 {
+		t.Errorf("%d cars stored, want 1", n)
+	}
+}
+
+func TestRetryIsReplayed(t *testing.T) {
+	idem := NewIdempotency(idempotency.NewMemoryStore(), time.Hour, time.Hour, discard)
+	srv := newTestServer(t, idem)
+
+	if status := post(t, srv.URL+"/v1/cars", "k", testCar(0, 1)); status != http.StatusCreated {
+		t.Fatalf("create = %d", status)
+	}
+	if status := post(t, srv.URL+"/v1/cars", "k", testCar(0, 1)); status != http.StatusCreated {
+		t.Errorf("replay = %d, want %d", status, http.StatusCreated)
+	}
+	if n := countCars(t, srv.URL); n != 1 {
+		t.Errorf("%d cars stored, want 1", n)
+	}
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"github.com/hecomp/cars/internal/telemetry/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// unmatchedRoute labels the requests no route matches, so that stray paths
+// share a single series.
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels the requests made with a method outside of HTTP's.
+const otherMethod = "OTHER"
+
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Instrument records the count, latency, size and number in flight of every
+// request next serves, errors and aborted streams included. Requests are
+// labelled with the route template route returns for them, their method and
+// the class of their status, never with ids or other values from the path.
+func Instrument(next http.Handler, route func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pattern := routeOf(r, route)
+		method := r.Method
+		if !knownMethods[method] {
+			method = otherMethod
+		}
+
+		inFlight := metrics.RequestsInFlight.WithLabelValues(pattern, method)
+		inFlight.Inc()
+		mw := &meteredWriter{ResponseWriter: w}
+		start := time.Now()
+		defer func() {
+			inFlight.Dec()
+			p := recover()
+			class := statusClass(mw.result(p != nil))
+			metrics.RequestsTotal.WithLabelValues(pattern, method, class).Inc()
+			metrics.RequestDuration.WithLabelValues(pattern, method, class).Observe(time.Since(start).Seconds())
+			metrics.ResponseSize.WithLabelValues(pattern, method, class).Observe(float64(mw.size))
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(mw, r)
+	})
+}
+
+// routeOf returns the route template route returns for r, or unmatchedRoute.
+func routeOf(r *http.Request, route func(*http.Request) string) string {
+	if pattern := route(r); pattern != "" {
+		return pattern
+	}
+	return unmatchedRoute
+}
+
+// statusClass turns a status into its class, such as 2xx.
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// meteredWriter records the status and body size of a response. It flushes
+// through to the underlying writer so streamed responses still stream.
+type meteredWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (mw *meteredWriter) WriteHeader(status int) {
+	if mw.status == 0 && status >= http.StatusOK {
+		mw.status = status
+	}
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *meteredWriter) Write(b []byte) (int, error) {
+	if mw.status == 0 {
+		mw.status = http.StatusOK
+	}
+	n, err := mw.ResponseWriter.Write(b)
+	mw.size += n
+	return n, err
+}
+
+// result returns the status the client got. A handler that panicked before
+// answering leaves the client with a broken connection, which counts as a
+// server error.
+func (mw *meteredWriter) result(panicked bool) int {
+	switch {
+	case mw.status != 0:
+		return mw.status
+	case panicked:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusOK
+	}
+}
+
+func (mw *meteredWriter) Flush() {
+	if f, ok := mw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/hecomp/cars/internal/telemetry/logging"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// RequestIDHeader carries the id correlating a request with its logs.
+	RequestIDHeader = "X-Request-ID"
+
+	maxRequestID = 128
+)
+
+// probeRoutes are polled every few seconds, so their successful requests are
+// only logged at debug level.
+var probeRoutes = map[string]bool{"/livez": true, "/readyz": true, "/health": true}
+
+// Log gives every request next serves an id, taken from its X-Request-ID
+// header when it has a valid one and generated otherwise. The id is sent back
+// in the response's header and added to every record logged with the
+// request's context, and an access log of the request is written once it is
+// answered.
+func Log(next http.Handler, logger *slog.Logger, route func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !printable(id, maxRequestID) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		r = r.WithContext(logging.WithRequestID(r.Context(), id))
+
+		mw := &meteredWriter{ResponseWriter: w}
+		start := time.Now()
+		defer func() {
+			p := recover()
+			pattern, status := routeOf(r, route), mw.result(p != nil)
+			level := slog.LevelInfo
+			if probeRoutes[pattern] && status < http.StatusBadRequest {
+				level = slog.LevelDebug
+			}
+			logger.LogAttrs(r.Context(), level, "Served request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("route", pattern),
+				slog.Int("status", status),
+				slog.Int("bytes", mw.size),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("remote", r.RemoteAddr))
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(mw, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
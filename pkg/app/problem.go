@@ -0,0 +1,111 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hecomp/cars/internal/constants"
+	"github.com/hecomp/cars/pkg/repository"
+	"github.com/hecomp/cars/pkg/services"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+const (
+	problemContentType = "application/problem+json"
+
+	// problemTypePrefix turns the code of a problem into its type URI.
+	problemTypePrefix = "urn:cars:problem:"
+)
+
+// errInternal stands in for errors that are not domain errors, so their
+// details are logged but never sent to clients.
+var errInternal = repository.NewError(repository.KindInternal, "internal_error", "the request could not be completed")
+
+var kindStatus = map[repository.Kind]int{
+	repository.KindInternal:     http.StatusInternalServerError,
+	repository.KindNotFound:     http.StatusNotFound,
+	repository.KindConflict:     http.StatusConflict,
+	repository.KindPrecondition: http.StatusPreconditionFailed,
+	repository.KindInvalid:      http.StatusBadRequest,
+	repository.KindValidation:   http.StatusUnprocessableEntity,
+}
+
+// codeStatus overrides the status of problems that HTTP has a more specific
+// status for than their kind.
+var codeStatus = map[string]int{
+	ErrPatchType.Code:        http.StatusUnsupportedMediaType,
+	ErrMethodNotAllowed.Code: http.StatusMethodNotAllowed,
+	ErrBatchTooLarge.Code:    http.StatusRequestEntityTooLarge,
+	ErrNotAcceptable.Code:    http.StatusNotAcceptable,
+	ErrImportType.Code:       http.StatusUnsupportedMediaType,
+}
+
+// problem maps err to the problem details describing it. Its detail is the
+// message of the domain error in err, or the fields of a validation error,
+// never the text wrapped around them, which may come from a decoder or the
+// database and is only logged.
+func problem(r *http.Request, err error) constants.Problem {
+	var derr *repository.Error
+	if !errors.As(err, &derr) || derr.Kind == repository.KindInternal {
+		derr = errInternal
+	}
+	detail := derr.Message
+	var verr *services.ValidationError
+	if derr.Kind == repository.KindValidation && errors.As(err, &verr) {
+		detail = verr.Error()
+	}
+	status, ok := codeStatus[derr.Code]
+	if !ok {
+		status = kindStatus[derr.Kind]
+	}
+	return constants.Problem{
+		Type:     problemTypePrefix + derr.Code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.RequestURI(),
+		Code:     derr.Code,
+		Fields:   fieldErrors(err),
+	}
+}
+
+// writeProblem logs err and answers with the problem details describing it.
+// Server errors are logged as errors, the client's own mistakes only as info.
+func (c *carsHandler) writeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	c.reportProblem(w, r, problem(r, err), err)
+}
+
+// reportProblem logs err and answers with p, the problem details describing
+// it.
+func (c *carsHandler) reportProblem(w http.ResponseWriter, r *http.Request, p constants.Problem, err error) {
+	level := slog.LevelInfo
+	if p.Status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	c.logger.Log(r.Context(), level, "Request failed", "status", p.Status, "code", p.Code, "error", err)
+	sendProblem(w, p)
+}
+
+func renderProblem(w http.ResponseWriter, r *http.Request, err error) {
+	sendProblem(w, problem(r, err))
+}
+
+func sendProblem(w http.ResponseWriter, p constants.Problem) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// fieldErrors returns the per-field messages of a validation error, or nil
+// when err is not one.
+func fieldErrors(err error) []constants.FieldError {
+	var verr *services.ValidationError
+	if !errors.As(err, &verr) {
+		return nil
+	}
+	fields := make([]constants.FieldError, len(verr.Fields))
+	for i, f := range verr.Fields {
+		fields[i] = constants.FieldError{Field: f.Field, Message: f.Message}
+	}
+	return fields
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hecomp/cars/internal/constants"
+	"github.com/hecomp/cars/pkg/repository"
+	"github.com/hecomp/cars/pkg/services"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProblem(t *testing.T) {
+	invalid := &services.ValidationError{Fields: []services.FieldError{
+		{Field: "make", Message: "is required"},
+		{Field: "year", Message: "must be between 1886 and 2027"},
+	}}
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantDetail string
+		wantFields []constants.FieldError
+	}{
+		{"not found", fmt.Errorf("%w: 42", repository.ErrNotFound), 404, repository.ErrNotFound.Code, repository.ErrNotFound.Message, nil},
+		{"conflict", fmt.Errorf("%w 1HGCM82633A004352", repository.ErrDuplicateVIN), 409, "duplicate_vin", repository.ErrDuplicateVIN.Message, nil},
+		{"precondition", fmt.Errorf("%w: car 42 is at version 3", repository.ErrVersionMismatch), 412, "version_mismatch", repository.ErrVersionMismatch.Message, nil},
+		{"invalid", fmt.Errorf("%w: unknown field \"weight\"", repository.ErrInvalidSort), 400, "invalid_sort", repository.ErrInvalidSort.Message, nil},
+		{"validation", invalid, 422, services.ErrValidation.Code, invalid.Error(), []constants.FieldError{
+			{Field: "make", Message: "is required"},
+			{Field: "year", Message: "must be between 1886 and 2027"},
+		}},
+		{"wrapped validation", fmt.Errorf("row 3: %w", invalid), 422, services.ErrValidation.Code, invalid.Error(), []constants.FieldError{
+			{Field: "make", Message: "is required"},
+			{Field: "year", Message: "must be between 1886 and 2027"},
+		}},
+		{"patch conflict", fmt.Errorf("%w: testing value /price failed", services.ErrPatchConflict), 409, "patch_conflict", services.ErrPatchConflict.Message, nil},
+
+		// Text wrapped around a domain error may come from a decoder or
+		// the database and is left out.
+		{"malformed body", fmt.Errorf("%w: json: cannot unmarshal string into Go struct field Car.year of type int", ErrMalformedBody),
+			400, "malformed_body", ErrMalformedBody.Message, nil},
+		{"database detail", fmt.Errorf("%w: UNIQUE constraint failed: cars.vin", repository.ErrDuplicateVIN),
+			409, "duplicate_vin", repository.ErrDuplicateVIN.Message, nil},
+
+		// Codes HTTP has a more specific status for than their kind.
+		{"method not allowed", ErrMethodNotAllowed, 405, "method_not_allowed", ErrMethodNotAllowed.Message, nil},
+		{"patch type", ErrPatchType, 415, ErrPatchType.Code, ErrPatchType.Message, nil},
+		{"import type", ErrImportType, 415, ErrImportType.Code, ErrImportType.Message, nil},
+		{"batch too large", ErrBatchTooLarge, 413, "batch_too_large", ErrBatchTooLarge.Message, nil},
+		{"not acceptable", ErrNotAcceptable, 406, ErrNotAcceptable.Code, ErrNotAcceptable.Message, nil},
+
+		// Anything else is hidden from the client.
+		{"plain error", errors.New("disk /var/lib/cars is on fire"), 500, "internal_error", errInternal.Message, nil},
+		{"internal domain error", fmt.Errorf("%w: table cars is locked", repository.NewError(repository.KindInternal, "db_locked", "database locked")),
+			500, "internal_error", errInternal.Message, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			renderProblem(rec, httptest.NewRequest(http.MethodGet, "/v1/cars/42?include=archived", nil), tt.err)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != problemContentType {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var p constants.Problem
+			if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+
+			want := constants.Problem{
+				Type:     problemTypePrefix + tt.wantCode,
+				Title:    http.StatusText(tt.wantStatus),
+				Status:   tt.wantStatus,
+				Detail:   tt.wantDetail,
+				Instance: "/v1/cars/42?include=archived",
+				Code:     tt.wantCode,
+				Fields:   tt.wantFields,
+			}
+			if !reflect.DeepEqual(p, want) {
+				t.Errorf("problem = %+v\nwant %+v", p, want)
+			}
+			if tt.wantDetail != tt.err.Error() && strings.Contains(rec.Body.String(), tt.err.Error()) {
+				t.Errorf("error leaked: %s", rec.Body)
+			}
+		})
+	}
+}
+
+// TestEveryKindHasAStatus guards against adding a kind without deciding how
+// it is reported.
+func TestEveryKindHasAStatus(t *testing.T) {
+	for kind := repository.KindInternal; kind <= repository.KindValidation; kind++ {
+		if kindStatus[kind] == 0 {
+			t.Errorf("kind %d has no status", kind)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"fmt"
+	"github.com/hecomp/cars/pkg/repository"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// parseQuery builds a repository query from the filter, sort and pagination
+// parameters of a listing request.
+func parseQuery(r *http.Request) (repository.Query, error) {
+	values := r.URL.Query()
+	q := repository.Query{
+		ReadOptions: readOptions(r),
+		Make:        values.Get("make"),
+		Model:       values.Get("model"),
+		Color:       values.Get("color"),
+		Category:    values.Get("category"),
+		Cursor:      values.Get("cursor"),
+	}
+
+	var err error
+	if q.Year, err = intParam(values, "year"); err != nil {
+		return q, err
+	}
+	if q.Limit, err = intParam(values, "limit"); err != nil {
+		return q, err
+	}
+	ranges := []struct {
+		name string
+		dst  **int
+	}{
+		{"minPrice", &q.MinPrice},
+		{"maxPrice", &q.MaxPrice},
+		{"minMileage", &q.MinMileage},
+		{"maxMileage", &q.MaxMileage},
+	}
+	for _, rng := range ranges {
+		if values.Get(rng.name) == "" {
+			continue
+		}
+		v, err := intParam(values, rng.name)
+		if err != nil {
+			return q, err
+		}
+		*rng.dst = &v
+	}
+	if q.Sort, err = repository.ParseSort(values.Get("sort")); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func intParam(values url.Values, name string) (int, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v %q must be an integer", ErrInvalidParam, name, raw)
+	}
+	return v, nil
+}
+
+func boolParam(values url.Values, name string) (bool, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v %q must be a boolean", ErrInvalidParam, name, raw)
+	}
+	return v, nil
+}
+
+// nextLink returns the URL of the page following the one served for r.
+func nextLink(r *http.Request, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	values := r.URL.Query()
+	values.Set("cursor", cursor)
+	return r.URL.Path + "?" + values.Encode()
+}
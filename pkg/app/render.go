@@ -0,0 +1,205 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/hecomp/cars/internal/constants"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/internal/telemetry/tracing"
+	"github.com/hecomp/cars/pkg/transfer"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Renderer encodes response bodies in one media type.
+type Renderer struct {
+	ContentType string
+	Encode      func(w io.Writer, v interface{}) error
+}
+
+var (
+	JSONRenderer = Renderer{ContentType: "application/json", Encode: func(w io.Writer, v interface{}) error {
+		return json.NewEncoder(w).Encode(v)
+	}}
+	XMLRenderer = Renderer{ContentType: "application/xml", Encode: func(w io.Writer, v interface{}) error {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		return xml.NewEncoder(w).Encode(v)
+	}}
+	MessagePackRenderer = Renderer{ContentType: "application/msgpack", Encode: func(w io.Writer, v interface{}) error {
+		enc := msgpack.NewEncoder(w)
+		// Keep the field names clients know from JSON.
+		enc.SetCustomStructTag("json")
+		return enc.Encode(v)
+	}}
+	// CSVRenderer and NDJSONRenderer write the cars of a listing, one per
+	// row or line, dropping the rest of the response.
+	CSVRenderer    = listRenderer(transfer.CSV)
+	NDJSONRenderer = listRenderer(transfer.NDJSON)
+)
+
+func listRenderer(f transfer.Format) Renderer {
+	return Renderer{ContentType: f.ContentType(), Encode: func(w io.Writer, v interface{}) error {
+		resp, ok := v.(*constants.UserResponse)
+		if !ok {
+			return fmt.Errorf("%v renders listings only, got %T", f, v)
+		}
+		cars, _ := resp.Data.([]*models.Car)
+		out := transfer.NewWriter(f, w)
+		for _, car := range cars {
+			if err := out.Write(car); err != nil {
+				return err
+			}
+		}
+		return out.Flush()
+	}}
+}
+
+var (
+	// Renderers are the media types every response can be rendered in, the
+	// first being the default.
+	Renderers = []Renderer{JSONRenderer, XMLRenderer, MessagePackRenderer}
+	// ListRenderers are the media types listings of cars can be rendered in.
+	ListRenderers = []Renderer{JSONRenderer, XMLRenderer, MessagePackRenderer, CSVRenderer, NDJSONRenderer}
+)
+
+// mediaAliases are other names clients send for the offered media types.
+var mediaAliases = map[string]string{
+	"application/x-msgpack":   "application/msgpack",
+	"application/vnd.msgpack": "application/msgpack",
+	"text/xml":                "application/xml",
+	"application/ndjson":      "application/x-ndjson",
+	"application/jsonl":       "application/x-ndjson",
+}
+
+type rendererKey struct{}
+
+// Negotiate picks the offer best matching the Accept header of each request
+// before calling h, so that h renders its response with it. Requests
+// accepting none of the offers are answered with 406 Not Acceptable.
+func Negotiate(h http.HandlerFunc, offers ...Renderer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		rd, ok := negotiate(r.Header.Get("Accept"), offers)
+		if !ok {
+			types := make([]string, len(offers))
+			for i, o := range offers {
+				types[i] = o.ContentType
+			}
+			renderProblem(w, r, fmt.Errorf("%w: %q, can produce %v", ErrNotAcceptable, r.Header.Get("Accept"), strings.Join(types, ", ")))
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), rendererKey{}, rd)))
+	}
+}
+
+// negotiate returns the offer with the highest quality in accept, the first
+// of them on a tie. An empty accept takes the first offer.
+func negotiate(accept string, offers []Renderer) (Renderer, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+	ranges := parseAccept(accept)
+	best, bestQ := Renderer{}, 0.0
+	for _, offer := range offers {
+		if q := quality(ranges, offer.ContentType); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		if alias, ok := mediaAliases[mt]; ok {
+			mt = alias
+		}
+		typ, subtype, ok := strings.Cut(mt, "/")
+		if !ok {
+			continue
+		}
+		mr := mediaRange{typ: typ, subtype: subtype, q: 1}
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if q, err := strconv.ParseFloat(v, 64); err == nil {
+					mr.q = q
+				}
+			}
+		}
+		ranges = append(ranges, mr)
+	}
+	return ranges
+}
+
+// quality returns the q of the most specific range matching contentType, or
+// 0 when none does.
+func quality(ranges []mediaRange, contentType string) float64 {
+	typ, subtype, _ := strings.Cut(contentType, "/")
+	q, specificity := 0.0, -1
+	for _, mr := range ranges {
+		s := -1
+		switch {
+		case mr.typ == typ && mr.subtype == subtype:
+			s = 2
+		case mr.typ == typ && mr.subtype == "*":
+			s = 1
+		case mr.typ == "*" && mr.subtype == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = mr.q, s
+		}
+	}
+	return q
+}
+
+// negotiated returns the renderer Negotiate picked for r, JSON when the route
+// does not negotiate.
+func negotiated(r *http.Request) Renderer {
+	if rd, ok := r.Context().Value(rendererKey{}).(Renderer); ok {
+		return rd
+	}
+	return JSONRenderer
+}
+
+// render writes status and v in the media type negotiated for r. The next page of a listing is also linked
+// from a Link header, as not every media type carries it in the body.
+func (c *carsHandler) render(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	renderTo(w, r, c.logger, status, v)
+}
+
+// renderTo is render for handlers other than carsHandler, logging encoding
+// errors to logger.
+func renderTo(w http.ResponseWriter, r *http.Request, logger *slog.Logger, status int, v interface{}) {
+	rd := negotiated(r)
+	if resp, ok := v.(*constants.UserResponse); ok && resp.Next != "" {
+		w.Header().Add("Link", "<"+resp.Next+`>; rel="next"`)
+	}
+	w.Header().Set("Content-Type", rd.ContentType)
+	w.WriteHeader(status)
+	_, span := tracer.Start(r.Context(), "render",
+		trace.WithAttributes(attribute.String("http.response_content_type", rd.ContentType)))
+	err := rd.Encode(w, v)
+	tracing.End(span, err)
+	if err != nil {
+		logger.ErrorCtx(r.Context(), "Error rendering response", "content_type", rd.ContentType, "error", err)
+	}
+}
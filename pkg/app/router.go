@@ -0,0 +1,236 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/hecomp/cars/pkg/repository"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoRoute          = repository.NewError(repository.KindNotFound, "no_route", "no such resource")
+	ErrMethodNotAllowed = repository.NewError(repository.KindInvalid, "method_not_allowed", "method not allowed")
+)
+
+type paramsKey struct{}
+
+// PathParam returns the value of the {name} segment of the route pattern the
+// request was matched against, or "" when there is none.
+func PathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+// Router dispatches requests by method and path pattern. Patterns are split
+// on "/" and each segment is either a literal, a {name} parameter matching
+// one non-empty segment, or a trailing * matching the rest of the path. A
+// path is served by the most specific pattern matching it, and when that
+// pattern has no handler for the request method it is answered with 405
+// Method Not Allowed and an Allow header.
+type Router struct {
+	routes []*route
+}
+
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]http.Handler
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers h for method requests matching pattern.
+func (rt *Router) Handle(method, pattern string, h http.Handler) {
+	for _, rte := range rt.routes {
+		if rte.pattern == pattern {
+			rte.handlers[method] = h
+			return
+		}
+	}
+	rt.routes = append(rt.routes, &route{
+		pattern:  pattern,
+		segments: split(pattern),
+		handlers: map[string]http.Handler{method: h},
+	})
+}
+
+// HandleFunc registers h for method requests matching pattern.
+func (rt *Router) HandleFunc(method, pattern string, h http.HandlerFunc) {
+	rt.Handle(method, pattern, h)
+}
+
+// Deprecation describes the retirement of a route.
+type Deprecation struct {
+	// Successor is the pattern clients should move to. Its parameters are
+	// filled in from the matched request.
+	Successor string
+	// Since is when the route was deprecated.
+	Since time.Time
+	// Sunset is when the route is expected to stop answering, or zero
+	// when no date has been set.
+	Sunset time.Time
+}
+
+// Deprecated registers h for method requests matching pattern like
+// HandleFunc, and marks every response with the Deprecation header of RFC
+// 9745, a Sunset header (RFC 8594) and a Link to the successor.
+func (rt *Router) Deprecated(method, pattern string, d Deprecation, h http.HandlerFunc) {
+	rt.HandleFunc(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+		segments := split(d.Successor)
+		for i, seg := range segments {
+			if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+				if v := PathParam(r, seg[1:len(seg)-1]); v != "" {
+					segments[i] = v
+				}
+			}
+		}
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+		if !d.Sunset.IsZero() {
+			w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Set("Link", "</"+strings.Join(segments, "/")+`>; rel="successor-version"`)
+		h(w, r)
+	})
+}
+
+// Pattern returns the pattern of the route serving r, or "" when no route
+// matches its path.
+func (rt *Router) Pattern(r *http.Request) string {
+	rte, _, _, _ := rt.lookup(r)
+	if rte == nil {
+		return ""
+	}
+	return rte.pattern
+}
+
+// lookup finds the route matching the path of r most specifically, along
+// with its handler for the method of r and the parameters of the path. When
+// that route has no handler for the method, allowed lists the methods it can
+// be requested with; less specific routes are not tried, so /v1/cars/batch
+// is never served as the car "batch".
+func (rt *Router) lookup(r *http.Request) (rte *route, h http.Handler, params map[string]string, allowed []string) {
+	path := split(r.URL.Path)
+	for _, candidate := range rt.routes {
+		p, ok := candidate.match(path)
+		if ok && (rte == nil || candidate.moreSpecific(rte)) {
+			rte, params = candidate, p
+		}
+	}
+	if rte == nil {
+		return nil, nil, nil, nil
+	}
+	h, ok := rte.handlers[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		h, ok = rte.handlers[http.MethodGet]
+	}
+	if !ok {
+		return rte, nil, nil, rte.methods()
+	}
+	return rte, h, params, nil
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, h, params, allowed := rt.lookup(r)
+	if h != nil {
+		if len(params) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+		}
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	if allowed == nil {
+		renderProblem(w, r, fmt.Errorf("%w: %v", ErrNoRoute, r.URL.Path))
+		return
+	}
+	allowed = append(allowed, http.MethodOptions)
+	w.Header().Set("Allow", strings.Join(dedupe(allowed), ", "))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	renderProblem(w, r, fmt.Errorf("%w: %v %v", ErrMethodNotAllowed, r.Method, r.URL.Path))
+}
+
+func (rte *route) match(path []string) (map[string]string, bool) {
+	var params map[string]string
+	for i, seg := range rte.segments {
+		if seg == "*" {
+			return params, true
+		}
+		if i >= len(path) {
+			return nil, false
+		}
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if path[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[seg[1:len(seg)-1]] = path[i]
+			continue
+		}
+		if seg != path[i] {
+			return nil, false
+		}
+	}
+	return params, len(path) == len(rte.segments)
+}
+
+// moreSpecific reports whether rte should serve a path other matches too: at
+// the first segment where they differ, a literal beats a parameter, which
+// beats a trailing *.
+func (rte *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(rte.segments) && i < len(other.segments); i++ {
+		a, b := segmentRank(rte.segments[i]), segmentRank(other.segments[i])
+		if a != b {
+			return a < b
+		}
+	}
+	return false
+}
+
+// segmentRank orders the kinds of segment from the most specific.
+func segmentRank(seg string) int {
+	switch {
+	case seg == "*":
+		return 2
+	case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (rte *route) methods() []string {
+	methods := make([]string, 0, len(rte.handlers)+1)
+	for m := range rte.handlers {
+		methods = append(methods, m)
+		if m == http.MethodGet {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	return methods
+}
+
+// split breaks a path into its segments, ignoring a trailing slash.
+func split(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func dedupe(methods []string) []string {
+	sort.Strings(methods)
+	out := methods[:0]
+	for i, m := range methods {
+		if i == 0 || m != methods[i-1] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
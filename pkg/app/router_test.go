@@ -0,0 +1,142 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// echo answers with the name it is given and the id parameter, if any.
+func echo(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+PathParam(r, "id"))
+	}
+}
+
+func testRouter() *Router {
+	router := NewRouter()
+	router.HandleFunc(http.MethodGet, "/v1/cars", echo("list"))
+	router.HandleFunc(http.MethodPost, "/v1/cars", echo("create"))
+	router.HandleFunc(http.MethodGet, "/v1/cars/{id}", echo("get "))
+	router.HandleFunc(http.MethodPut, "/v1/cars/{id}", echo("update "))
+	router.HandleFunc(http.MethodPost, "/v1/cars/batch", echo("batch"))
+	router.HandleFunc(http.MethodGet, "/swagger/*", echo("swagger"))
+	router.Deprecated(http.MethodGet, "/car/{id}", Deprecation{
+		Successor: "/v1/cars/{id}",
+		Since:     time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC),
+		Sunset:    time.Date(2027, time.April, 18, 0, 0, 0, 0, time.UTC),
+	}, echo("legacy "))
+	router.Deprecated(http.MethodPost, "/create", Deprecation{
+		Successor: "/v1/cars",
+		Since:     time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC),
+	}, echo("legacy create"))
+	return router
+}
+
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantBody     string
+		wantAllow    string
+		wantPattern  string
+	}{
+		{"GET", "/v1/cars", 200, "list", "", "/v1/cars"},
+		{"GET", "/v1/cars/", 200, "list", "", "/v1/cars"},
+		{"POST", "/v1/cars", 200, "create", "", "/v1/cars"},
+		{"GET", "/v1/cars/abc", 200, "get abc", "", "/v1/cars/{id}"},
+		{"HEAD", "/v1/cars/abc", 200, "get abc", "", "/v1/cars/{id}"},
+		{"PUT", "/v1/cars/abc", 200, "update abc", "", "/v1/cars/{id}"},
+		{"POST", "/v1/cars/batch", 200, "batch", "", "/v1/cars/batch"},
+		{"GET", "/swagger/index.html", 200, "swagger", "", "/swagger/*"},
+
+		// A literal route matching the path answers for it, whatever the
+		// method, rather than the {id} route behind it.
+		{"PUT", "/v1/cars/batch", 405, "", "OPTIONS, POST", "/v1/cars/batch"},
+		{"GET", "/v1/cars/batch", 405, "", "OPTIONS, POST", "/v1/cars/batch"},
+		{"OPTIONS", "/v1/cars/batch", 204, "", "OPTIONS, POST", "/v1/cars/batch"},
+		{"DELETE", "/v1/cars", 405, "", "GET, HEAD, OPTIONS, POST", "/v1/cars"},
+		{"DELETE", "/v1/cars/abc", 405, "", "GET, HEAD, OPTIONS, PUT", "/v1/cars/{id}"},
+		{"OPTIONS", "/v1/cars", 204, "", "GET, HEAD, OPTIONS, POST", "/v1/cars"},
+		{"POST", "/car/abc", 405, "", "GET, HEAD, OPTIONS", "/car/{id}"},
+
+		{"GET", "/v1/cars/abc/def", 404, "", "", ""},
+		{"GET", "/v1/trucks", 404, "", "", ""},
+	}
+	router := testRouter()
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == 200 && rec.Body.String() != tt.wantBody {
+				t.Errorf("served by %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if allow := rec.Header().Get("Allow"); allow != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", allow, tt.wantAllow)
+			}
+			if tt.wantStatus >= 400 && rec.Header().Get("Content-Type") != problemContentType {
+				t.Errorf("error answered as %q", rec.Header().Get("Content-Type"))
+			}
+			if pattern := router.Pattern(req); pattern != tt.wantPattern {
+				t.Errorf("Pattern = %q, want %q", pattern, tt.wantPattern)
+			}
+		})
+	}
+}
+
+func TestDeprecatedRoute(t *testing.T) {
+	tests := []struct {
+		method, path string
+		wantBody     string
+		wantSunset   string
+		wantLink     string
+	}{
+		{"GET", "/car/abc", "legacy abc", "Sun, 18 Apr 2027 00:00:00 GMT", `</v1/cars/abc>; rel="successor-version"`},
+		{"POST", "/create", "legacy create", "", `</v1/cars>; rel="successor-version"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			testRouter().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if body := rec.Body.String(); body != tt.wantBody {
+				t.Errorf("served by %q", body)
+			}
+			if got, want := rec.Header().Get("Deprecation"), "@1792281600"; got != want {
+				t.Errorf("Deprecation = %q, want %q", got, want)
+			}
+			if got := rec.Header().Get("Sunset"); got != tt.wantSunset {
+				t.Errorf("Sunset = %q, want %q", got, tt.wantSunset)
+			}
+			if link := rec.Header().Get("Link"); link != tt.wantLink {
+				t.Errorf("Link = %q, want %q", link, tt.wantLink)
+			}
+		})
+	}
+}
+
+// TestDeprecatedListingLinks checks that a deprecated listing links both to
+// its successor and to its next page.
+func TestDeprecatedListingLinks(t *testing.T) {
+	srv := newTestServer(t, nil)
+	for n := 0; n < 2; n++ {
+		do(t, http.MethodPost, srv.URL+"/v1/cars", testCar(0, n))
+	}
+
+	resp, err := http.Get(srv.URL + "/cars?limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	links := resp.Header.Values("Link")
+	if len(links) != 2 || links[0] != `</v1/cars>; rel="successor-version"` {
+		t.Errorf("Link = %q, want the successor and the next page", links)
+	}
+}
@@ -2,21 +2,89 @@ package app
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
-func NewRoute(handler CarsHandler) *http.ServeMux {
+// The legacy routes were deprecated when /v1 was introduced and are retired
+// six months later.
+var (
+	legacyDeprecated = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	legacySunset     = legacyDeprecated.AddDate(0, 6, 0)
+)
+
+// NewRoute maps the API onto handler. Creates and batches honour
+// Idempotency-Key through idem, which may be nil to ignore the header.
+// Liveness and readiness are exposed to probes through probes.
+func NewRoute(handler CarsHandler, idem *Idempotency, probes *Health) *Router {
+
+	// Responses are rendered in the media type negotiated from Accept, and
+	// listings can also be CSV. Negotiation comes first so that a write is
+	// refused before it is made when its response could not be sent.
+	var (
+		getCars     = Negotiate(handler.GetCars, ListRenderers...)
+		searchCars  = Negotiate(handler.SearchCars, ListRenderers...)
+		getCar      = Negotiate(handler.GetCar, Renderers...)
+		getCarByVIN = Negotiate(handler.GetCarByVIN, Renderers...)
+		createCar   = Negotiate(idem.Wrap("create", handler.CreateCar), Renderers...)
+		updateCar   = Negotiate(handler.UpdateCar, Renderers...)
+		patchCar    = Negotiate(handler.PatchCar, Renderers...)
+		deleteCar   = Negotiate(handler.DeleteCar, Renderers...)
+		restoreCar  = Negotiate(handler.RestoreCar, Renderers...)
+		batchCars   = Negotiate(idem.Wrap("batch", handler.BatchCars), Renderers...)
+		importCars  = Negotiate(handler.ImportCars, Renderers...)
+		livez       = Negotiate(probes.Livez, Renderers...)
+		readyz      = Negotiate(probes.Readyz, Renderers...)
+	)
+
+	router := NewRouter()
+	router.HandleFunc(http.MethodGet, "/v1/cars", getCars)
+	router.HandleFunc(http.MethodPost, "/v1/cars", createCar)
+	router.HandleFunc(http.MethodPost, "/v1/cars/batch", batchCars)
+	router.HandleFunc(http.MethodGet, "/v1/cars/export", handler.ExportCars)
+	router.HandleFunc(http.MethodPost, "/v1/cars/import", importCars)
+	router.HandleFunc(http.MethodGet, "/v1/cars/vin/{vin}", getCarByVIN)
+	router.HandleFunc(http.MethodGet, "/v1/cars/{id}", getCar)
+	router.HandleFunc(http.MethodPut, "/v1/cars/{id}", updateCar)
+	router.HandleFunc(http.MethodPatch, "/v1/cars/{id}", patchCar)
+	router.HandleFunc(http.MethodDelete, "/v1/cars/{id}", deleteCar)
+	router.HandleFunc(http.MethodPost, "/v1/cars/{id}/restore", restoreCar)
+	router.HandleFunc(http.MethodGet, "/v1/search", searchCars)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/car/", handler.GetCar)          // GET
-	mux.HandleFunc("/cars", handler.GetCars)         // GET
-	mux.HandleFunc("/create", handler.CreateCar)     // POST
-	mux.HandleFunc("/update", handler.UpdateCar)     // PUT
-	mux.HandleFunc("/health", handler.HealthHandler) // GET
-	mux.HandleFunc("/swagger/", httpSwagger.WrapHandler)
-	mux.Handle("/metrics", promhttp.Handler())
+	// Legacy paths, kept until clients have moved to /v1.
+	legacy := func(successor string) Deprecation {
+		return Deprecation{Successor: successor, Since: legacyDeprecated, Sunset: legacySunset}
+	}
+	router.Deprecated(http.MethodGet, "/car/vin/{vin}", legacy("/v1/cars/vin/{vin}"), getCarByVIN)
+	router.Deprecated(http.MethodGet, "/car/{id}", legacy("/v1/cars/{id}"), getCar)
+	router.Deprecated(http.MethodPatch, "/car/{id}", legacy("/v1/cars/{id}"), patchCar)
+	router.Deprecated(http.MethodDelete, "/car/{id}", legacy("/v1/cars/{id}"), deleteCar)
+	router.Deprecated(http.MethodPost, "/car/{id}/restore", legacy("/v1/cars/{id}/restore"), restoreCar)
+	router.Deprecated(http.MethodPost, "/create", legacy("/v1/cars"), createCar)
+	router.Deprecated(http.MethodPut, "/update", legacy("/v1/cars/{id}"), updateCar)
+	router.Deprecated(http.MethodGet, "/health", legacy("/livez"), livez)
+	router.Deprecated(http.MethodGet, "/cars", legacy("/v1/cars"), getCars)
+	router.Deprecated(http.MethodPost, "/cars/batch", legacy("/v1/cars/batch"), batchCars)
+	router.Deprecated(http.MethodGet, "/cars/export", legacy("/v1/cars/export"), handler.ExportCars)
+	router.Deprecated(http.MethodPost, "/cars/import", legacy("/v1/cars/import"), importCars)
+	router.Deprecated(http.MethodGet, "/search", legacy("/v1/search"), searchCars)
+
+	router.HandleFunc(http.MethodGet, "/livez", livez)
+	router.HandleFunc(http.MethodGet, "/readyz", readyz)
+	router.Handle(http.MethodGet, "/swagger/*", httpSwagger.WrapHandler)
+	router.Handle(http.MethodGet, "/metrics", promhttp.Handler())
+
+	return router
+}
 
-	return mux
+// NewAdminRoute maps the endpoints operators change the running service
+// through. They are served apart from the API, on a listener that can be
+// kept off the public network.
+func NewAdminRoute(logLevel *LogLevel) *Router {
+	router := NewRouter()
+	router.HandleFunc(http.MethodGet, "/admin/log-level", logLevel.GetLogLevel)
+	router.HandleFunc(http.MethodPut, "/admin/log-level", logLevel.SetLogLevel)
+	return router
 }
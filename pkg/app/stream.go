@@ -0,0 +1,133 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/transfer"
+	"io"
+	"net/http"
+)
+
+// streamFlushEvery is how many cars are written between flushes of a
+// stream, so clients see it arrive while it is read.
+const streamFlushEvery = 500
+
+// carStream writes a listing of cars as they come.
+type carStream interface {
+	Write(car *models.Car) error
+	// Flush writes out anything buffered.
+	Flush() error
+	// Close ends the listing and flushes it.
+	Close() error
+}
+
+// streams makes the streams of the media types a listing can be streamed in.
+var streams = map[string]func(w io.Writer) carStream{
+	JSONRenderer.ContentType: func(w io.Writer) carStream { return &jsonStream{w: w} },
+	NDJSONRenderer.ContentType: func(w io.Writer) carStream {
+		return transferStream{transfer.NewWriter(transfer.NDJSON, w)}
+	},
+	CSVRenderer.ContentType: func(w io.Writer) carStream {
+		return transferStream{transfer.NewWriter(transfer.CSV, w)}
+	},
+}
+
+// jsonStream writes a listing in the shape of a JSON response, as
+// {"data":[...]}, one car at a time.
+type jsonStream struct {
+	w io.Writer
+	n int
+}
+
+func (s *jsonStream) Write(car *models.Car) error {
+	sep := ","
+	if s.n == 0 {
+		sep = `{"data":[`
+	}
+	s.n++
+	if _, err := io.WriteString(s.w, sep); err != nil {
+		return err
+	}
+	data, err := json.Marshal(car)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(data)
+	return err
+}
+
+func (s *jsonStream) Flush() error {
+	return nil
+}
+
+func (s *jsonStream) Close() error {
+	end := "]}\n"
+	if s.n == 0 {
+		end = `{"data":[` + end
+	}
+	_, err := io.WriteString(s.w, end)
+	return err
+}
+
+type transferStream struct {
+	transfer.Writer
+}
+
+func (s transferStream) Close() error {
+	return s.Flush()
+}
+
+// streamCars writes the cars produce yields for r to w through a stream made by
+// newStream, flushing every streamFlushEvery of them. When produce yields
+// none, empty is returned instead, unless it is nil. Every flush extends the
+// write deadline, so a stream runs for as long as the client keeps reading.
+//
+// An error is only returned while nothing has reached the client, which can
+// then still be answered with a problem. After that the response is cut
+// short instead, so a truncated listing can't pass for a complete one.
+func (c *carsHandler) streamCars(w http.ResponseWriter, r *http.Request, newStream func(io.Writer) carStream, empty error, produce func(fn func(*models.Car) error) error) error {
+	sw := &sentWriter{ResponseWriter: w}
+	out := newStream(sw)
+	flusher, _ := w.(http.Flusher)
+	n := 0
+	err := produce(func(car *models.Car) error {
+		if err := out.Write(car); err != nil {
+			return err
+		}
+		if n++; n%streamFlushEvery == 0 {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			extendWriteDeadline(r)
+		}
+		return nil
+	})
+	if err == nil && n == 0 && empty != nil {
+		err = empty
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil && !sw.sent {
+		return err
+	}
+	if err != nil {
+		c.logger.ErrorCtx(r.Context(), "Error streaming cars", "sent", n, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
+
+// sentWriter records whether anything has been written to the client.
+type sentWriter struct {
+	http.ResponseWriter
+	sent bool
+}
+
+func (sw *sentWriter) Write(b []byte) (int, error) {
+	sw.sent = true
+	return sw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+var tracer = otel.Tracer("github.com/hecomp/cars/pkg/app")
+
+// Trace starts a span for every request next serves, named after the route
+// template route returns for it. The span continues the trace of the W3C
+// traceparent header of the request, when there is one, and its own
+// traceparent is sent back so clients can find it. Requests answered with a
+// server error mark their span as failed.
+func Trace(next http.Handler, route func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pattern := routeOf(r, route)
+		propagator := otel.GetTextMapPropagator()
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+pattern,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(r.Method),
+				semconv.HTTPRoute(pattern),
+				semconv.HTTPTarget(r.URL.RequestURI()),
+			))
+		propagator.Inject(ctx, propagation.HeaderCarrier(w.Header()))
+
+		mw := &meteredWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			status := mw.result(p != nil)
+			span.SetAttributes(semconv.HTTPStatusCode(status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			span.End()
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(mw, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,173 @@
+package app
+
+import (
+	"fmt"
+	"github.com/hecomp/cars/internal/constants"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"github.com/hecomp/cars/pkg/transfer"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrFormat        = repository.NewError(repository.KindInvalid, "unsupported_format", "format must be csv or ndjson")
+	ErrNotAcceptable = repository.NewError(repository.KindInvalid, "not_acceptable", "none of the accepted media types can be produced")
+	ErrImportType    = repository.NewError(repository.KindInvalid, "unsupported_import_type", "import must be text/csv or application/x-ndjson")
+)
+
+// exportFormat picks the format of an export from ?format=, or else from the
+// Accept header, defaulting to CSV.
+func exportFormat(r *http.Request) (transfer.Format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		f, ok := transfer.ParseFormat(name)
+		if !ok {
+			return "", fmt.Errorf("%w: got %q", ErrFormat, name)
+		}
+		return f, nil
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return transfer.CSV, nil
+	}
+	for _, mt := range strings.Split(accept, ",") {
+		mt = strings.TrimSpace(strings.Split(mt, ";")[0])
+		if mt == "*/*" {
+			return transfer.CSV, nil
+		}
+		if f, ok := transfer.ParseFormat(mt); ok {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %v", ErrNotAcceptable, accept)
+}
+
+// importFormat picks the format of an import from ?format=, or else from the
+// Content-Type header.
+func importFormat(r *http.Request) (transfer.Format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		f, ok := transfer.ParseFormat(name)
+		if !ok {
+			return "", fmt.Errorf("%w: got %q", ErrFormat, name)
+		}
+		return f, nil
+	}
+	f, ok := transfer.ParseFormat(r.Header.Get("Content-Type"))
+	if !ok {
+		return "", fmt.Errorf("%w: got %q", ErrImportType, r.Header.Get("Content-Type"))
+	}
+	return f, nil
+}
+
+// ExportCars godoc
+//
+//	@Summary	Export cars
+//	@Schemes
+//	@Description	Streams every car, in id order, as CSV or newline delimited JSON. The format is taken from format, or else from the Accept header, and defaults to CSV.
+//	@Tags			read
+//	@Produce		text/csv
+//	@Produce		application/x-ndjson
+//	@Param			format	query	string	false	"csv or ndjson"
+//	@Param			include	query	string	false	"Set to archived to include soft deleted cars"
+//	@Success		200
+//	@Failure		400	{object}	constants.Problem
+//	@Failure		406	{object}	constants.Problem
+//	@Failure		500	{object}	constants.Problem
+//	@Router			/v1/cars/export [get]
+func (c *carsHandler) ExportCars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	format, err := exportFormat(r)
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="cars.%v"`, format))
+	err = c.streamCars(w, r, streams[format.ContentType()], nil, func(fn func(*models.Car) error) error {
+		return c.services.EachCar(r.Context(), repository.Query{ReadOptions: readOptions(r)}, fn)
+	})
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		c.writeProblem(w, r, err)
+		return
+	}
+}
+
+// ImportCars godoc
+//
+//	@Summary	Import cars
+//	@Schemes
+//	@Description	Reads cars from a CSV or newline delimited JSON upload, creating the rows without an id and updating those with one, pinned to their version when set. Columns, or keys, are car fields unless renamed by map. Every row is checked and written on its own, and the rows that failed are reported by line; the response is 207 when some did. A dry run reports what the import would do without writing anything.
+//	@Tags			write
+//	@Accept			text/csv
+//	@Accept			application/x-ndjson
+//	@Produce		json,xml,application/msgpack
+//	@Param			format	query		string	false	"csv or ndjson, overriding Content-Type"
+//	@Param			map		query		string	false	"Column to field renames, e.g. Brand:make,Colour:color,Notes:-"
+//	@Param			dryRun	query		bool	false	"Check the rows without writing them"
+//	@Success		200		{object}	constants.UserResponse{data=constants.ImportReport}
+//	@Success		207		{object}	constants.UserResponse{data=constants.ImportReport}
+//	@Failure		400		{object}	constants.Problem
+//	@Failure		406		{object}	constants.Problem
+//	@Failure		415		{object}	constants.Problem
+//	@Failure		500		{object}	constants.Problem
+//	@Router			/v1/cars/import [post]
+func (c *carsHandler) ImportCars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	values := r.URL.Query()
+	format, err := importFormat(r)
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	mapping, err := transfer.ParseMapping(values.Get("map"))
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	dryRun, err := boolParam(values, "dryRun")
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+
+	// An upload is read for as long as it keeps coming, and answered once
+	// all of it has been imported.
+	extendReadDeadline(r)
+	rows, err := transfer.NewReader(format, r.Body, mapping)
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+	report, err := c.services.Import(r.Context(), rows, dryRun)
+	extendWriteDeadline(r)
+	if err != nil {
+		c.writeProblem(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	out := constants.ImportReport{
+		DryRun:  dryRun,
+		Rows:    report.Rows,
+		Created: report.Created,
+		Updated: report.Updated,
+		Failed:  len(report.Errors),
+	}
+	for _, rowErr := range report.Errors {
+		p := problem(r, rowErr.Err)
+		out.Errors = append(out.Errors, constants.ImportError{Line: rowErr.Line, Error: p})
+		status = http.StatusMultiStatus
+	}
+	message := CarsImportedSuccess
+	if dryRun {
+		message = DryRunSuccess
+	}
+	c.render(w, r, status, &constants.UserResponse{
+		Message: message,
+		Data:    out,
+	})
+}
@@ -0,0 +1,39 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Writable checks that files can still be created in dir, as a log or
+// database kept there needs.
+func Writable(dir string) Check {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".health-*")
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		err = f.Close()
+		if rerr := os.Remove(name); err == nil {
+			err = rerr
+		}
+		return err
+	}
+}
+
+// DiskSpace checks that at least min bytes are free on the file system
+// holding path.
+func DiskSpace(path string, min uint64) Check {
+	return func(ctx context.Context) error {
+		free, err := diskFree(path)
+		if err != nil {
+			return err
+		}
+		if free < min {
+			return fmt.Errorf("%d bytes free on %v, below %d", free, path, min)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package health
+
+import "math"
+
+// diskFree can't tell the free space outside of unix, where DiskSpace always
+// passes.
+func diskFree(path string) (uint64, error) {
+	return math.MaxUint64, nil
+}
@@ -0,0 +1,125 @@
+// Package health decides whether the service is ready for traffic, from the
+// checks its components register on the dependencies they rely on.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusUp   = "UP"
+	StatusDown = "DOWN"
+)
+
+var ErrShuttingDown = errors.New("shutting down")
+
+// Check returns nil when the dependency it checks is healthy. It should give
+// up once ctx is done.
+type Check func(ctx context.Context) error
+
+type check struct {
+	name    string
+	timeout time.Duration
+	fn      Check
+}
+
+// Registry holds the checks readiness is decided from. A registry is ready
+// while every check passes and it is not draining.
+type Registry struct {
+	mutex    sync.Mutex
+	checks   []check
+	draining int32
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a check named name, which fails when it takes longer than
+// timeout.
+func (r *Registry) Register(name string, timeout time.Duration, fn Check) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.checks = append(r.checks, check{name: name, timeout: timeout, fn: fn})
+}
+
+// Drain makes the registry report itself down from now on, so that load
+// balancers stop sending requests while those in flight are finished.
+func (r *Registry) Drain() {
+	atomic.StoreInt32(&r.draining, 1)
+}
+
+// Run runs every check concurrently and reports their results in the order
+// they were registered. The report is down when any check fails, and without
+// running them when the registry is draining.
+func (r *Registry) Run(ctx context.Context) *models.HealthResponse {
+	if atomic.LoadInt32(&r.draining) == 1 {
+		return &models.HealthResponse{
+			Status: StatusDown,
+			Checks: []models.HealthCheck{{Name: "shutdown", Status: StatusDown, Error: ErrShuttingDown.Error()}},
+		}
+	}
+
+	r.mutex.Lock()
+	checks := append([]check(nil), r.checks...)
+	r.mutex.Unlock()
+
+	report := &models.HealthResponse{Status: StatusUp, Checks: make([]models.HealthCheck, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+// run runs c, which fails on timeout even when it does not give up itself.
+func (c check) run(ctx context.Context) models.HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("check panicked: %v", p)
+			}
+		}()
+		done <- c.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("no answer within %v: %w", c.timeout, ctx.Err())
+	}
+
+	result := models.HealthCheck{
+		Name:       c.name,
+		Status:     StatusUp,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status, result.Error = StatusDown, err.Error()
+	}
+	return result
+}
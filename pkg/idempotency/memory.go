@@ -0,0 +1,77 @@
+package idempotency
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryStore struct {
+	mutex   *sync.Mutex
+	records map[string]*Record
+}
+
+// NewMemoryStore returns a store that keeps records in memory, so they are
+// lost on restart.
+func NewMemoryStore() Store {
+	return memoryStore{mutex: &sync.Mutex{}, records: make(map[string]*Record)}
+}
+
+func (s memoryStore) Reserve(key, fingerprint string, now, expiredBefore, staleBefore time.Time) (*Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if rec, ok := s.records[key]; ok && !rec.CreatedAt.Before(expiredBefore) &&
+		!(rec.Pending() && rec.CreatedAt.Before(staleBefore)) {
+		stored := *rec
+		return &stored, nil
+	}
+	s.records[key] = &Record{Key: key, Fingerprint: fingerprint, CreatedAt: now}
+	return nil, nil
+}
+
+func (s memoryStore) Complete(rec *Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.reserved(rec.Key, rec.CreatedAt) {
+		return ErrNotReserved
+	}
+	stored := *rec
+	s.records[rec.Key] = &stored
+	return nil
+}
+
+func (s memoryStore) Release(key string, reservedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.reserved(key, reservedAt) {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+// reserved reports whether key is still held by the reservation made at
+// reservedAt. It must be called with the mutex held.
+func (s memoryStore) reserved(key string, reservedAt time.Time) bool {
+	rec, ok := s.records[key]
+	return ok && rec.Pending() && rec.CreatedAt.Equal(reservedAt)
+}
+
+func (s memoryStore) Purge(before time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	n := 0
+	for key, rec := range s.records {
+		if rec.CreatedAt.Before(before) {
+			delete(s.records, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s memoryStore) Close() error {
+	return nil
+}
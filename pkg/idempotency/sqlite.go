@@ -0,0 +1,121 @@
+package idempotency
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// schema is kept apart from the repository's migrations, which own PRAGMA
+// user_version, so it only ever creates what is missing.
+const schema = `CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key         TEXT PRIMARY KEY,
+	fingerprint TEXT NOT NULL,
+	created_at  INTEGER NOT NULL,
+	status      INTEGER NOT NULL DEFAULT 0,
+	header      TEXT,
+	body        BLOB
+)`
+
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (or creates) the SQLite database at path and keeps
+// records in its idempotency_keys table. Pointing it at the repository's
+// database keeps cars and the responses that created them together.
+func NewSQLiteStore(path string) (Store, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite %v: %w", path, err)
+	}
+	if _, err = db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create idempotency_keys: %w", err)
+	}
+	return sqliteStore{db: db}, nil
+}
+
+func (s sqliteStore) Reserve(key, fingerprint string, now, expiredBefore, staleBefore time.Time) (*Record, error) {
+	for {
+		// Take the key when it is free, expired or left pending past its
+		// lease, in one statement so two requests can never both reserve it.
+		res, err := s.db.Exec(`INSERT INTO idempotency_keys (key, fingerprint, created_at) VALUES (?, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET fingerprint = excluded.fingerprint,
+				created_at = excluded.created_at, status = 0, header = NULL, body = NULL
+			WHERE idempotency_keys.created_at < ?
+				OR (idempotency_keys.status = 0 AND idempotency_keys.created_at < ?)`,
+			key, fingerprint, now.UnixNano(), expiredBefore.UnixNano(), staleBefore.UnixNano())
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n > 0 {
+			return nil, nil
+		}
+
+		rec := &Record{Key: key}
+		var created int64
+		var header sql.NullString
+		err = s.db.QueryRow(`SELECT fingerprint, created_at, status, header, body FROM idempotency_keys WHERE key = ?`, key).
+			Scan(&rec.Fingerprint, &created, &rec.Status, &header, &rec.Body)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Released in between, try to take it again.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec.CreatedAt = time.Unix(0, created)
+		if header.Valid {
+			if err = json.Unmarshal([]byte(header.String), &rec.Header); err != nil {
+				return nil, fmt.Errorf("decode idempotency record %v: %w", key, err)
+			}
+		}
+		return rec, nil
+	}
+}
+
+func (s sqliteStore) Complete(rec *Record) error {
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE idempotency_keys SET status = ?, header = ?, body = ?
+		WHERE key = ? AND created_at = ? AND status = 0`,
+		rec.Status, string(header), rec.Body, rec.Key, rec.CreatedAt.UnixNano())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotReserved
+	}
+	return nil
+}
+
+func (s sqliteStore) Release(key string, reservedAt time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE key = ? AND created_at = ? AND status = 0`,
+		key, reservedAt.UnixNano())
+	return err
+}
+
+func (s sqliteStore) Purge(before time.Time) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s sqliteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,89 @@
+// Package idempotency stores the responses of requests sent with an
+// Idempotency-Key so that retries get the original response instead of
+// repeating the request.
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+// ErrNotReserved is returned when a request completes a key whose
+// reservation was taken over after its lease ran out.
+var ErrNotReserved = errors.New("idempotency key is no longer reserved by this request")
+
+// Record is the response stored under an idempotency key. A record with a
+// zero Status is reserved by a request that is still in flight.
+type Record struct {
+	Key string
+	// Fingerprint identifies the request the key was first used with.
+	Fingerprint string
+	CreatedAt   time.Time
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+// Pending reports whether the request holding the record is still running.
+func (r *Record) Pending() bool {
+	return r.Status == 0
+}
+
+// Store keeps idempotency records. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Reserve claims key for a new request. It returns nil when the key was
+	// free, had a record created before expiredBefore, or was reserved
+	// before staleBefore by a request that never completed it, and is now
+	// reserved; otherwise it returns the record holding the key.
+	Reserve(key, fingerprint string, now, expiredBefore, staleBefore time.Time) (*Record, error)
+	// Complete stores the response of the request that reserved rec.Key at
+	// rec.CreatedAt, or returns ErrNotReserved when the key has been taken
+	// over since.
+	Complete(rec *Record) error
+	// Release frees the key reserved at reservedAt so the request can be
+	// retried. A reservation that has been taken over is left alone.
+	Release(key string, reservedAt time.Time) error
+	// Purge removes the records created before the given time and returns
+	// how many were removed.
+	Purge(before time.Time) (int, error)
+	Close() error
+}
+
+// Fingerprint hashes what makes two requests the same request: the
+// operation they ask for and their body.
+func Fingerprint(operation string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(operation))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RunJanitor purges records older than ttl from store every interval until
+// ctx is done.
+func RunJanitor(ctx context.Context, store Store, ttl, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := store.Purge(time.Now().Add(-ttl))
+			if err != nil {
+				logger.ErrorCtx(ctx, "Error purging idempotency keys", "error", err)
+				continue
+			}
+			if n > 0 {
+				logger.InfoCtx(ctx, "Purged expired idempotency keys", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package idempotency
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stores returns a fresh store of every kind.
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	sqlite, err := NewSQLiteStore(filepath.Join(t.TempDir(), "idempotency.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	return map[string]Store{"memory": NewMemoryStore(), "sqlite": sqlite}
+}
+
+func TestReserve(t *testing.T) {
+	const (
+		ttl   = time.Hour
+		lease = time.Minute
+	)
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name string
+		// completed completes the first reservation before the second.
+		completed bool
+		after     time.Duration
+		wantTaken bool
+	}{
+		{"pending within its lease", false, lease / 2, false},
+		{"pending past its lease", false, lease + time.Second, true},
+		{"completed past the lease", true, lease + time.Second, false},
+		{"completed past the ttl", true, ttl + time.Second, true},
+	}
+	for name, store := range stores(t) {
+		for i, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				key := string(rune('a' + i))
+				if rec, err := store.Reserve(key, "f", start, start.Add(-ttl), start.Add(-lease)); rec != nil || err != nil {
+					t.Fatalf("first reserve = %v, %v", rec, err)
+				}
+				if tt.completed {
+					if err := store.Complete(&Record{Key: key, Fingerprint: "f", CreatedAt: start, Status: 201}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				now := start.Add(tt.after)
+				rec, err := store.Reserve(key, "f", now, now.Add(-ttl), now.Add(-lease))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if taken := rec == nil; taken != tt.wantTaken {
+					t.Fatalf("second reserve took the key: %v, want %v", taken, tt.wantTaken)
+				}
+				if !tt.wantTaken && rec.Pending() == tt.completed {
+					t.Errorf("record pending: %v, want %v", rec.Pending(), !tt.completed)
+				}
+			})
+		}
+	}
+}
+
+// TestTakenOverReservation checks that the request whose reservation was
+// taken over can neither complete nor release the key any more.
+func TestTakenOverReservation(t *testing.T) {
+	lease := time.Minute
+	first := time.Unix(1700000000, 0)
+	second := first.Add(2 * lease)
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.Reserve("k", "f", first, first.Add(-time.Hour), first.Add(-lease))
+			if rec, _ := store.Reserve("k", "f", second, second.Add(-time.Hour), second.Add(-lease)); rec != nil {
+				t.Fatal("an expired lease was not taken over")
+			}
+
+			err := store.Complete(&Record{Key: "k", Fingerprint: "f", CreatedAt: first, Status: 201})
+			if !errors.Is(err, ErrNotReserved) {
+				t.Errorf("complete of a lost reservation = %v, want %v", err, ErrNotReserved)
+			}
+			if err = store.Release("k", first); err != nil {
+				t.Fatal(err)
+			}
+			rec, err := store.Reserve("k", "f", second, second.Add(-time.Hour), second.Add(-lease))
+			if err != nil || rec == nil || !rec.CreatedAt.Equal(second) {
+				t.Fatalf("the new reservation was released: %v, %v", rec, err)
+			}
+
+			if err = store.Complete(&Record{Key: "k", Fingerprint: "f", CreatedAt: second, Status: 201}); err != nil {
+				t.Errorf("complete by the new owner: %v", err)
+			}
+		})
+	}
+}
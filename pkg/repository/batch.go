@@ -0,0 +1,248 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/utils"
+	"time"
+)
+
+// OpKind is the kind of write a batch operation performs.
+type OpKind string
+
+const (
+	OpCreate OpKind = "create"
+	OpUpdate OpKind = "update"
+	OpDelete OpKind = "delete"
+)
+
+// Op is a single write of a batch. Create and update take Car; delete takes
+// Id and archives the car unless Permanent is set.
+type Op struct {
+	Kind      OpKind
+	Car       *models.Car
+	Id        string
+	Permanent bool
+}
+
+// Result is the outcome of one operation of a batch. Car is the car as
+// stored after the operation, nil for a permanent delete or a failure.
+type Result struct {
+	Car *models.Car
+	Err error
+}
+
+// BatchMode chooses what a batch does when one of its operations fails.
+type BatchMode int
+
+const (
+	// AllOrNothing applies every operation or none of them.
+	AllOrNothing BatchMode = iota
+	// BestEffort applies the operations that succeed and reports the
+	// others in their results.
+	BestEffort
+	// DryRun runs the operations like BestEffort and reports their results
+	// without writing anything.
+	DryRun
+)
+
+var (
+	// ErrInvalidOp is returned, wrapped, for an operation of an unknown kind
+	// or without the car or id it needs.
+	ErrInvalidOp = NewError(KindInvalid, "invalid_operation", "invalid batch operation")
+	// ErrNotApplied is the result of the operations of an all-or-nothing
+	// batch that were rolled back because another one failed.
+	ErrNotApplied = NewError(KindConflict, "not_applied", "not applied, the batch was rolled back")
+)
+
+// abort marks every successful result as rolled back.
+func abort(results []Result) []Result {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = Result{Err: ErrNotApplied}
+		}
+	}
+	return results
+}
+
+// validOp checks that op carries what its kind needs.
+func validOp(op Op) error {
+	switch op.Kind {
+	case OpCreate, OpUpdate:
+		if op.Car == nil {
+			return fmt.Errorf("%w: %v needs a car", ErrInvalidOp, op.Kind)
+		}
+	case OpDelete:
+		if op.Id == "" {
+			return fmt.Errorf("%w: delete needs an id", ErrInvalidOp)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidOp, op.Kind)
+	}
+	return nil
+}
+
+// batchTx stages the writes of a batch on top of the repository so they can
+// be checked against each other and then committed, or dropped, as a whole.
+type batchTx struct {
+	r repository
+	// cars holds the staged state of every car touched; nil means removed.
+	cars map[string]*models.Car
+	// vins holds the staged owner of every VIN touched; "" means released.
+	vins    map[string]string
+	records []*logRecord
+}
+
+func (r repository) begin() *batchTx {
+	return &batchTx{r: r, cars: make(map[string]*models.Car), vins: make(map[string]string)}
+}
+
+func (tx *batchTx) find(id string) (*models.Car, bool) {
+	if car, ok := tx.cars[id]; ok {
+		return car, car != nil
+	}
+	car, ok := tx.r.Storage[id]
+	return car, ok
+}
+
+func (tx *batchTx) vinOwner(vin string) (string, bool) {
+	if vin == "" {
+		return "", false
+	}
+	if id, ok := tx.vins[vin]; ok {
+		return id, id != ""
+	}
+	id, ok := tx.r.index.vins[vin]
+	return id, ok
+}
+
+// put stages a copy of car, so the caller's car never ends up stored.
+func (tx *batchTx) put(car *models.Car) {
+	car = car.Clone()
+	if old, ok := tx.find(car.Id); ok && old.VIN != "" {
+		tx.vins[old.VIN] = ""
+	}
+	if car.VIN != "" {
+		tx.vins[car.VIN] = car.Id
+	}
+	tx.cars[car.Id] = car
+	tx.records = append(tx.records, &logRecord{Op: opPut, Car: car})
+}
+
+func (tx *batchTx) remove(car *models.Car) {
+	if car.VIN != "" {
+		tx.vins[car.VIN] = ""
+	}
+	tx.cars[car.Id] = nil
+	tx.records = append(tx.records, &logRecord{Op: opDelete, Car: car})
+}
+
+// apply stages op with the same checks as the single-car methods.
+func (tx *batchTx) apply(op Op) (*models.Car, error) {
+	if err := validOp(op); err != nil {
+		return nil, err
+	}
+	switch op.Kind {
+	case OpCreate:
+		car := op.Car
+		if _, ok := tx.find(car.Id); ok {
+			return nil, fmt.Errorf("%w %v", ErrDuplicateCar, car.Id)
+		}
+		if _, ok := tx.vinOwner(car.VIN); ok {
+			return nil, fmt.Errorf("%w %v", ErrDuplicateVIN, car.VIN)
+		}
+		now := time.Now().UTC()
+		car.Id = utils.GenId(9)
+		car.CreatedAt = &now
+		car.DeletedAt = nil
+		car.Version = 1
+		tx.put(car)
+		return car, nil
+
+	case OpUpdate:
+		car := op.Car
+		stored, ok := tx.find(car.Id)
+		if !ok || stored.Archived() {
+			return nil, fmt.Errorf("%w %v", ErrNotFound, car.Id)
+		}
+		if car.Version != 0 && car.Version != stored.Version {
+			return nil, fmt.Errorf("%w: car %v is at version %d", ErrVersionMismatch, car.Id, stored.Version)
+		}
+		if owner, ok := tx.vinOwner(car.VIN); ok && owner != car.Id {
+			return nil, fmt.Errorf("%w %v", ErrDuplicateVIN, car.VIN)
+		}
+		car.CreatedAt = stored.CreatedAt
+		car.DeletedAt = nil
+		car.Version = stored.Version + 1
+		tx.put(car)
+		return car, nil
+
+	default:
+		stored, ok := tx.find(op.Id)
+		if !ok || (stored.Archived() && !op.Permanent) {
+			return nil, fmt.Errorf("%w %v", ErrNotFound, op.Id)
+		}
+		if op.Permanent {
+			tx.remove(stored)
+			return nil, nil
+		}
+		now := time.Now().UTC()
+		archived := *stored
+		archived.DeletedAt = &now
+		archived.Version++
+		tx.put(&archived)
+		return &archived, nil
+	}
+}
+
+// commit persists the staged writes as a single log record, so a crash
+// never leaves half a batch behind, and then applies them.
+func (tx *batchTx) commit() error {
+	if len(tx.records) == 0 {
+		return nil
+	}
+	r := tx.r
+	if err := r.persist(&logRecord{Op: opBatch, Batch: tx.records}); err != nil {
+		return err
+	}
+	for _, rec := range tx.records {
+		if old, ok := r.Storage[rec.Car.Id]; ok {
+			r.index.remove(old)
+		}
+		if rec.Op == opDelete {
+			delete(r.Storage, rec.Car.Id)
+			r.notify(rec.Car.Id, nil)
+			continue
+		}
+		r.Storage[rec.Car.Id] = rec.Car
+		r.index.add(rec.Car)
+		r.notify(rec.Car.Id, rec.Car)
+	}
+	r.compact()
+	return nil
+}
+
+func (r repository) Batch(ctx context.Context, ops []Op, mode BatchMode) ([]Result, error) {
+	if err := r.mutex.Lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.mutex.Unlock()
+
+	tx := r.begin()
+	results := make([]Result, len(ops))
+	for i, op := range ops {
+		car, err := tx.apply(op)
+		results[i] = Result{Car: car, Err: err}
+		if err != nil && mode == AllOrNothing {
+			return abort(results), fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	if mode == DryRun {
+		return results, nil
+	}
+	if err := tx.commit(); err != nil {
+		return abort(results), err
+	}
+	return results, nil
+}
@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/hecomp/cars/internal/models"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	vinA = "1HGCM82633A004352"
+	vinC = "1FAFP4040WF100000"
+)
+
+// batchFixture saves cars a, holding vinA, and b, and returns them along
+// with a batch that creates c with vinC, updates a, archives b, and then
+// fails twice: creating d with the VIN of a, and e with the VIN c got
+// earlier in the same batch.
+func batchFixture(t *testing.T, repo Repository) (a, b *models.Car, ops []Op) {
+	t.Helper()
+	ctx := context.Background()
+	a = newCar(0, 1)
+	a.VIN = vinA
+	a, err := repo.Save(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = repo.Save(ctx, newCar(0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, d, e := newCar(1, 3), newCar(1, 4), newCar(1, 5)
+	c.VIN, d.VIN, e.VIN = vinC, vinA, vinC
+	update := a.Clone()
+	update.Price = 999
+	return a, b, []Op{
+		{Kind: OpCreate, Car: c},
+		{Kind: OpUpdate, Car: update},
+		{Kind: OpDelete, Id: b.Id},
+		{Kind: OpCreate, Car: d},
+		{Kind: OpCreate, Car: e},
+	}
+}
+
+// snapshot returns every stored car, archived or not, ordered by id.
+func snapshot(t *testing.T, repo Repository) []*models.Car {
+	t.Helper()
+	cars, err := repo.List(context.Background(), ReadOptions{IncludeArchived: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(cars, func(i, j int) bool { return cars[i].Id < cars[j].Id })
+	return cars
+}
+
+// recordEvents subscribes to repo and returns the events it is told about.
+func recordEvents(repo Repository) *[]Event {
+	var events []Event
+	repo.Subscribe(func(e Event) {
+		events = append(events, e)
+	})
+	return &events
+}
+
+// checkUnchanged checks that a batch that should not have written left repo
+// as before: the same cars, the same answers from its indexes and no events.
+func checkUnchanged(t *testing.T, repo Repository, before []*models.Car, a *models.Car, events *[]Event) {
+	t.Helper()
+	ctx := context.Background()
+	if after := snapshot(t, repo); !reflect.DeepEqual(after, before) {
+		t.Errorf("cars changed:\n got %+v\nwant %+v", after, before)
+	}
+	if _, err := repo.FindByVIN(ctx, vinC, ReadOptions{IncludeArchived: true}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("find by the vin of a rolled back create = %v, want %v", err, ErrNotFound)
+	}
+	if got, err := repo.FindByVIN(ctx, vinA, ReadOptions{}); err != nil || got.Id != a.Id || got.Price != a.Price {
+		t.Errorf("find by vin = %+v, %v, want %+v", got, err, a)
+	}
+	page, err := repo.Query(ctx, Query{MinPrice: intp(999)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Cars) != 0 {
+		t.Errorf("query by a rolled back price found %d cars", len(page.Cars))
+	}
+	page, err = repo.Query(ctx, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Cars) != 2 {
+		t.Errorf("query found %d live cars, want 2", len(page.Cars))
+	}
+	if len(*events) != 0 {
+		t.Errorf("listeners were told about %+v", *events)
+	}
+}
+
+func TestBatchAllOrNothing(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		a, _, ops := batchFixture(t, repo)
+		before := snapshot(t, repo)
+		events := recordEvents(repo)
+
+		results, err := repo.Batch(context.Background(), ops, AllOrNothing)
+		if !errors.Is(err, ErrDuplicateVIN) || !strings.Contains(err.Error(), "operation 3") {
+			t.Fatalf("batch = %v, want %v at operation 3", err, ErrDuplicateVIN)
+		}
+		want := []error{ErrNotApplied, ErrNotApplied, ErrNotApplied, ErrDuplicateVIN, ErrNotApplied}
+		if len(results) != len(want) {
+			t.Fatalf("%d results, want %d", len(results), len(want))
+		}
+		for i, result := range results {
+			if !errors.Is(result.Err, want[i]) || result.Car != nil {
+				t.Errorf("result %d = %+v, want %v", i, result, want[i])
+			}
+		}
+		checkUnchanged(t, repo, before, a, events)
+	})
+}
+
+// checkBestEffort checks the results of the fixture's batch when every
+// operation is attempted.
+func checkBestEffort(t *testing.T, results []Result, a, b *models.Car) {
+	t.Helper()
+	if len(results) != 5 {
+		t.Fatalf("%d results, want 5", len(results))
+	}
+	if c := results[0]; c.Err != nil || c.Car == nil || c.Car.Id == "" || c.Car.Version != 1 {
+		t.Errorf("create = %+v", c)
+	}
+	if u := results[1]; u.Err != nil || u.Car == nil || u.Car.Id != a.Id || u.Car.Price != 999 || u.Car.Version != 2 {
+		t.Errorf("update = %+v", u)
+	}
+	if d := results[2]; d.Err != nil || d.Car == nil || d.Car.Id != b.Id || d.Car.DeletedAt == nil {
+		t.Errorf("archive = %+v", d)
+	}
+	for _, i := range []int{3, 4} {
+		if !errors.Is(results[i].Err, ErrDuplicateVIN) || results[i].Car != nil {
+			t.Errorf("result %d = %+v, want %v", i, results[i], ErrDuplicateVIN)
+		}
+	}
+}
+
+func TestBatchBestEffort(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		a, b, ops := batchFixture(t, repo)
+		events := recordEvents(repo)
+
+		results, err := repo.Batch(ctx, ops, BestEffort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkBestEffort(t, results, a, b)
+
+		c, err := repo.FindByVIN(ctx, vinC, ReadOptions{})
+		if err != nil || c.Id != results[0].Car.Id {
+			t.Errorf("find created car by vin = %+v, %v", c, err)
+		}
+		if got, err := repo.Find(ctx, a.Id, ReadOptions{}); err != nil || got.Price != 999 || got.Version != 2 {
+			t.Errorf("updated car = %+v, %v", got, err)
+		}
+		if _, err = repo.Find(ctx, b.Id, ReadOptions{}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("find archived car = %v, want %v", err, ErrNotFound)
+		}
+		if cars := snapshot(t, repo); len(cars) != 3 {
+			t.Errorf("stored %d cars, want 3", len(cars))
+		}
+		page, err := repo.Query(ctx, Query{MinPrice: intp(999)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Cars) != 1 || page.Cars[0].Id != a.Id {
+			t.Errorf("query by the new price = %+v, want car a", page.Cars)
+		}
+
+		wantIds := []string{results[0].Car.Id, a.Id, b.Id}
+		if len(*events) != len(wantIds) {
+			t.Fatalf("listeners were told about %d changes, want %d", len(*events), len(wantIds))
+		}
+		for i, e := range *events {
+			if e.Id != wantIds[i] || e.Car == nil || e.Car.Version != results[i].Car.Version {
+				t.Errorf("event %d = %+v, want the result of operation %d", i, e, i)
+			}
+		}
+	})
+}
+
+func TestBatchDryRun(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		a, b, ops := batchFixture(t, repo)
+		before := snapshot(t, repo)
+		events := recordEvents(repo)
+
+		results, err := repo.Batch(context.Background(), ops, DryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkBestEffort(t, results, a, b)
+		checkUnchanged(t, repo, before, a, events)
+	})
+}
+
+func TestBatchPermanentDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		a, b, _ := batchFixture(t, repo)
+		events := recordEvents(repo)
+
+		results, err := repo.Batch(ctx, []Op{
+			{Kind: OpDelete, Id: a.Id, Permanent: true},
+			{Kind: OpCreate, Car: &models.Car{Make: "ford", VIN: vinA}},
+			{Kind: OpDelete, Id: b.Id},
+			{Kind: OpDelete, Id: b.Id},
+		}, BestEffort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[0].Err != nil || results[0].Car != nil {
+			t.Errorf("permanent delete = %+v", results[0])
+		}
+		if results[1].Err != nil {
+			t.Errorf("create with the vin of a car deleted earlier in the batch = %v", results[1].Err)
+		}
+		if !errors.Is(results[3].Err, ErrNotFound) {
+			t.Errorf("archiving an archived car = %v, want %v", results[3].Err, ErrNotFound)
+		}
+		if _, err = repo.Find(ctx, a.Id, ReadOptions{IncludeArchived: true}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("find deleted car = %v, want %v", err, ErrNotFound)
+		}
+		if len(*events) != 3 || (*events)[0].Id != a.Id || (*events)[0].Car != nil {
+			t.Errorf("events = %+v, want the delete, create and archive", *events)
+		}
+	})
+}
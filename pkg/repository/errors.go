@@ -0,0 +1,51 @@
+package repository
+
+import "errors"
+
+// Kind classifies a domain error by what went wrong, independently of how
+// it is reported to clients.
+type Kind int
+
+const (
+	// KindInternal is an unexpected failure the client cannot fix.
+	KindInternal Kind = iota
+	// KindNotFound means the addressed car does not exist.
+	KindNotFound
+	// KindConflict means the request clashes with the current state.
+	KindConflict
+	// KindPrecondition means a version the request was pinned to is stale.
+	KindPrecondition
+	// KindInvalid means the request itself is malformed.
+	KindInvalid
+	// KindValidation means the car in the request breaks a business rule.
+	KindValidation
+)
+
+// Error is a domain error. Code is a stable, machine-readable identifier
+// clients can rely on, and the message is safe to show to them. Errors are
+// returned wrapped with details, so compare them with errors.Is and read
+// them with errors.As.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+}
+
+// NewError returns a domain error of the given kind.
+func NewError(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// KindOf returns the kind of the first domain error in err's chain, or
+// KindInternal when there is none.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindInternal
+}
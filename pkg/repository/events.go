@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"github.com/hecomp/cars/internal/models"
+	"sync"
+)
+
+// Event describes a committed change to a car. Car holds a copy of the car as
+// stored after the change and is nil when the car was permanently removed.
+type Event struct {
+	Id  string
+	Car *models.Car
+}
+
+// Listener is called after every committed change. Listeners run on the
+// writer's goroutine, must be quick and must not call back into the
+// repository. With backends that allow concurrent writers, events for the
+// same car may arrive out of order; Car.Version tells them apart.
+type Listener func(Event)
+
+type listeners struct {
+	mutex sync.RWMutex
+	fns   []Listener
+}
+
+func (l *listeners) Subscribe(fn Listener) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.fns = append(l.fns, fn)
+}
+
+func (l *listeners) notify(id string, car *models.Car) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	for _, fn := range l.fns {
+		fn(Event{Id: id, Car: car.Clone()})
+	}
+}
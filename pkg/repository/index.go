@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"github.com/google/btree"
+	"github.com/hecomp/cars/internal/models"
+	"math"
+	"strings"
+)
+
+const (
+	btreeDegree = 32
+
+	// maxSortedCandidates is the largest equality match set the planner will
+	// sort in full rather than walk an ordered index.
+	maxSortedCandidates = 10000
+)
+
+type idSet map[string]struct{}
+
+// indexEntry orders cars by an integer field, breaking ties by id.
+type indexEntry struct {
+	value int
+	id    string
+}
+
+func lessEntry(a, b indexEntry) bool {
+	if a.value != b.value {
+		return a.value < b.value
+	}
+	return a.id < b.id
+}
+
+// carIndex holds the secondary indexes of the in-memory repository. It is
+// guarded by the repository mutex and must be updated together with Storage.
+type carIndex struct {
+	makes      map[string]idSet
+	models     map[string]idSet
+	categories map[string]idSet
+	years      map[int]idSet
+	// vins maps each VIN in use to the id of the car holding it.
+	vins map[string]string
+
+	ids      *btree.BTreeG[indexEntry]
+	prices   *btree.BTreeG[indexEntry]
+	mileages *btree.BTreeG[indexEntry]
+}
+
+func newCarIndex() *carIndex {
+	return &carIndex{
+		makes:      make(map[string]idSet),
+		models:     make(map[string]idSet),
+		categories: make(map[string]idSet),
+		years:      make(map[int]idSet),
+		vins:       make(map[string]string),
+		ids:        btree.NewG(btreeDegree, lessEntry),
+		prices:     btree.NewG(btreeDegree, lessEntry),
+		mileages:   btree.NewG(btreeDegree, lessEntry),
+	}
+}
+
+func addToSet[K comparable](sets map[K]idSet, key K, id string) {
+	set, ok := sets[key]
+	if !ok {
+		set = make(idSet)
+		sets[key] = set
+	}
+	set[id] = struct{}{}
+}
+
+func removeFromSet[K comparable](sets map[K]idSet, key K, id string) {
+	set := sets[key]
+	delete(set, id)
+	if len(set) == 0 {
+		delete(sets, key)
+	}
+}
+
+func (ix *carIndex) add(car *models.Car) {
+	addToSet(ix.makes, strings.ToLower(car.Make), car.Id)
+	addToSet(ix.models, strings.ToLower(car.Model), car.Id)
+	addToSet(ix.categories, strings.ToLower(car.Category), car.Id)
+	addToSet(ix.years, car.Year, car.Id)
+	if car.VIN != "" {
+		ix.vins[car.VIN] = car.Id
+	}
+	ix.ids.ReplaceOrInsert(indexEntry{id: car.Id})
+	ix.prices.ReplaceOrInsert(indexEntry{value: car.Price, id: car.Id})
+	ix.mileages.ReplaceOrInsert(indexEntry{value: car.Mileage, id: car.Id})
+}
+
+func (ix *carIndex) remove(car *models.Car) {
+	removeFromSet(ix.makes, strings.ToLower(car.Make), car.Id)
+	removeFromSet(ix.models, strings.ToLower(car.Model), car.Id)
+	removeFromSet(ix.categories, strings.ToLower(car.Category), car.Id)
+	removeFromSet(ix.years, car.Year, car.Id)
+	if ix.vins[car.VIN] == car.Id {
+		delete(ix.vins, car.VIN)
+	}
+	ix.ids.Delete(indexEntry{id: car.Id})
+	ix.prices.Delete(indexEntry{value: car.Price, id: car.Id})
+	ix.mileages.Delete(indexEntry{value: car.Mileage, id: car.Id})
+}
+
+// query plans and runs q against the indexes. Equality filters on make,
+// model, category and year narrow the candidates to the smallest matching
+// set; otherwise a query sorted by id, price or mileage walks that ordered
+// index from the cursor and stops as soon as the page is full. Every
+// candidate is still checked against the complete filter.
+func (ix *carIndex) query(q Query, after *models.Car, storage map[string]*models.Car) *Page {
+	var (
+		candidates idSet
+		narrowed   bool
+	)
+	narrow := func(set idSet) {
+		if !narrowed || len(set) < len(candidates) {
+			candidates = set
+			narrowed = true
+		}
+	}
+	if q.Make != "" {
+		narrow(ix.makes[strings.ToLower(q.Make)])
+	}
+	if q.Model != "" {
+		narrow(ix.models[strings.ToLower(q.Model)])
+	}
+	if q.Category != "" {
+		narrow(ix.categories[strings.ToLower(q.Category)])
+	}
+	if q.Year != 0 {
+		narrow(ix.years[q.Year])
+	}
+
+	if narrowed && len(candidates) <= maxSortedCandidates {
+		return q.paginate(ix.collect(q, candidates, storage), after)
+	}
+	if tree, field, desc, ok := ix.orderedBy(q); ok {
+		return ix.walk(q, after, tree, field, desc, storage)
+	}
+	if narrowed {
+		return q.paginate(ix.collect(q, candidates, storage), after)
+	}
+	if q.MinPrice != nil || q.MaxPrice != nil {
+		return q.paginate(ix.collectRange(q, ix.prices, q.MinPrice, q.MaxPrice, storage), after)
+	}
+	if q.MinMileage != nil || q.MaxMileage != nil {
+		return q.paginate(ix.collectRange(q, ix.mileages, q.MinMileage, q.MaxMileage, storage), after)
+	}
+
+	cars := make([]*models.Car, 0)
+	for _, car := range storage {
+		if q.matches(car) {
+			cars = append(cars, car)
+		}
+	}
+	return q.paginate(cars, after)
+}
+
+func (ix *carIndex) collect(q Query, ids idSet, storage map[string]*models.Car) []*models.Car {
+	cars := make([]*models.Car, 0, len(ids))
+	for id := range ids {
+		if car := storage[id]; q.matches(car) {
+			cars = append(cars, car)
+		}
+	}
+	return cars
+}
+
+func (ix *carIndex) collectRange(q Query, tree *btree.BTreeG[indexEntry], min, max *int, storage map[string]*models.Car) []*models.Car {
+	cars := make([]*models.Car, 0)
+	iter := func(e indexEntry) bool {
+		if max != nil && e.value > *max {
+			return false
+		}
+		if car := storage[e.id]; q.matches(car) {
+			cars = append(cars, car)
+		}
+		return true
+	}
+	if min != nil {
+		tree.AscendGreaterOrEqual(indexEntry{value: *min}, iter)
+	} else {
+		tree.Ascend(iter)
+	}
+	return cars
+}
+
+// orderedBy returns the index whose order is exactly the sort order of q,
+// if there is one.
+func (ix *carIndex) orderedBy(q Query) (*btree.BTreeG[indexEntry], string, bool, bool) {
+	first := q.Sort[0]
+	switch {
+	case len(q.Sort) == 1 && first.Field == "id":
+		return ix.ids, "id", first.Desc, true
+	case len(q.Sort) == 2 && q.Sort[1].Desc == first.Desc:
+		switch first.Field {
+		case "price":
+			return ix.prices, "price", first.Desc, true
+		case "mileage":
+			return ix.mileages, "mileage", first.Desc, true
+		}
+	}
+	return nil, "", false, false
+}
+
+// walk fills a page by iterating tree in sort order, starting just past the
+// cursor or at the bound of a range filter on the indexed field.
+func (ix *carIndex) walk(q Query, after *models.Car, tree *btree.BTreeG[indexEntry], field string, desc bool, storage map[string]*models.Car) *Page {
+	var min, max *int
+	switch field {
+	case "price":
+		min, max = q.MinPrice, q.MaxPrice
+	case "mileage":
+		min, max = q.MinMileage, q.MaxMileage
+	}
+	entryOf := func(car *models.Car) indexEntry {
+		e := indexEntry{id: car.Id}
+		if field != "id" {
+			e.value = sortFields[field].value(car).(int)
+		}
+		return e
+	}
+
+	page := &Page{Cars: make([]*models.Car, 0)}
+	iter := func(e indexEntry) bool {
+		if after != nil && e == entryOf(after) {
+			return true
+		}
+		if (!desc && max != nil && e.value > *max) || (desc && min != nil && e.value < *min) {
+			return false
+		}
+		if car := storage[e.id]; q.matches(car) {
+			page.Cars = append(page.Cars, car)
+		}
+		return len(page.Cars) <= q.Limit
+	}
+
+	switch {
+	case after != nil && !desc:
+		tree.AscendGreaterOrEqual(entryOf(after), iter)
+	case after != nil && desc:
+		tree.DescendLessOrEqual(entryOf(after), iter)
+	case !desc && min != nil:
+		tree.AscendGreaterOrEqual(indexEntry{value: *min}, iter)
+	case desc && max != nil && *max < math.MaxInt:
+		// No car has an empty id, so this pivot sits just above every
+		// entry valued *max.
+		tree.DescendLessOrEqual(indexEntry{value: *max + 1}, iter)
+	case !desc:
+		tree.Ascend(iter)
+	default:
+		tree.Descend(iter)
+	}
+
+	if len(page.Cars) > q.Limit {
+		page.Cars = page.Cars[:q.Limit]
+		page.Next = q.encodeCursor(page.Cars[len(page.Cars)-1])
+	}
+	return page
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"github.com/hecomp/cars/internal/models"
+)
+
+// iteratorPageSize is how many cars the in-memory iterator reads at once.
+const iteratorPageSize = 500
+
+// Iterator walks the cars of a query one at a time:
+//
+//	it, err := repo.Iterate(ctx, q)
+//	...
+//	defer it.Close()
+//	for it.Next() {
+//		car := it.Car()
+//	}
+//	err = it.Err()
+type Iterator interface {
+	// Next advances to the next car. It returns false once there are no
+	// more, or when the iteration failed or its context was cancelled.
+	Next() bool
+	// Car returns the car Next advanced to.
+	Car() *models.Car
+	// Err returns the error that stopped the iteration, if any.
+	Err() error
+	Close() error
+}
+
+// pageIterator reads a query a page at a time, so the repository is only
+// locked while each page is read and writes can go on in between. Pages
+// follow each other by cursor, so a car changed meanwhile is seen at most
+// once.
+type pageIterator struct {
+	ctx   context.Context
+	query func(context.Context, Query) (*Page, error)
+	q     Query
+	cars  []*models.Car
+	car   *models.Car
+	last  bool
+	err   error
+}
+
+func (r repository) Iterate(ctx context.Context, q Query) (Iterator, error) {
+	q.Limit = iteratorPageSize
+	if _, err := q.normalize(); err != nil {
+		return nil, err
+	}
+	return &pageIterator{ctx: ctx, query: r.Query, q: q}, nil
+}
+
+func (it *pageIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.err = it.ctx.Err(); it.err != nil {
+		return false
+	}
+	if len(it.cars) == 0 {
+		if it.last {
+			return false
+		}
+		page, err := it.query(it.ctx, it.q)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.cars, it.q.Cursor, it.last = page.Cars, page.Next, page.Next == ""
+		if len(it.cars) == 0 {
+			return false
+		}
+	}
+	it.car, it.cars = it.cars[0], it.cars[1:]
+	return true
+}
+
+func (it *pageIterator) Car() *models.Car {
+	return it.car
+}
+
+func (it *pageIterator) Err() error {
+	return it.err
+}
+
+func (it *pageIterator) Close() error {
+	it.cars, it.last = nil, true
+	return nil
+}
@@ -0,0 +1,310 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultLimit is the page size used when a query does not set one.
+	DefaultLimit = 100
+	// MaxLimit caps the page size a query may ask for.
+	MaxLimit = 1000
+)
+
+var (
+	// ErrInvalidSort is returned, wrapped, for sort keys on unknown fields.
+	ErrInvalidSort = NewError(KindInvalid, "invalid_sort", "invalid sort")
+	// ErrInvalidCursor is returned, wrapped, for cursors that were not
+	// produced by the same query.
+	ErrInvalidCursor = NewError(KindInvalid, "invalid_cursor", "invalid cursor")
+)
+
+// Query describes a filtered, sorted and paginated listing of cars. String
+// filters match case-insensitively; zero values and nil ranges don't filter.
+type Query struct {
+	ReadOptions
+
+	Make     string
+	Model    string
+	Color    string
+	Category string
+	Year     int
+
+	MinPrice   *int
+	MaxPrice   *int
+	MinMileage *int
+	MaxMileage *int
+
+	// Sort lists the keys to order by. The car id is always appended as a
+	// final tie breaker, in the direction of the last key, so that
+	// pagination is stable.
+	Sort []SortKey
+	// Limit is the maximum number of cars on the page.
+	Limit int
+	// Cursor is the Page.Next value of the previous page.
+	Cursor string
+}
+
+// SortKey orders a query by a single field.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Page is one page of query results. Next is empty on the last page.
+type Page struct {
+	Cars []*models.Car
+	Next string
+}
+
+// sortFields maps the sortable field names to how they are compared and
+// which column backs them in SQL.
+var sortFields = map[string]struct {
+	column string
+	value  func(*models.Car) interface{}
+}{
+	"id":       {"id", func(c *models.Car) interface{} { return c.Id }},
+	"make":     {"make", func(c *models.Car) interface{} { return c.Make }},
+	"model":    {"model", func(c *models.Car) interface{} { return c.Model }},
+	"color":    {"color", func(c *models.Car) interface{} { return c.Color }},
+	"category": {"category", func(c *models.Car) interface{} { return c.Category }},
+	"year":     {"year", func(c *models.Car) interface{} { return c.Year }},
+	"price":    {"price", func(c *models.Car) interface{} { return c.Price }},
+	"mileage":  {"mileage", func(c *models.Car) interface{} { return c.Mileage }},
+}
+
+// ParseSort parses a comma separated list of fields, each optionally
+// prefixed with "-" for descending order, e.g. "-price,year".
+func ParseSort(s string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key := SortKey{Field: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		if _, ok := sortFields[key.Field]; !ok {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidSort, key.Field)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// normalize validates q and fills in the defaults shared by all backends.
+func (q Query) normalize() (Query, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	keys := make([]SortKey, 0, len(q.Sort)+1)
+	for _, key := range q.Sort {
+		if _, ok := sortFields[key.Field]; !ok {
+			return q, fmt.Errorf("%w: unknown field %q", ErrInvalidSort, key.Field)
+		}
+		keys = append(keys, key)
+		if key.Field == "id" {
+			// Ids are unique, so later keys can never make a difference.
+			break
+		}
+	}
+	if len(keys) == 0 {
+		keys = append(keys, SortKey{Field: "id"})
+	} else if last := keys[len(keys)-1]; last.Field != "id" {
+		// The tie breaker follows the direction of the last key so that the
+		// order matches the (value, id) order of the in-memory indexes.
+		keys = append(keys, SortKey{Field: "id", Desc: last.Desc})
+	}
+	q.Sort = keys
+	return q, nil
+}
+
+// sortSpec is embedded in cursors so a cursor can't be replayed against a
+// differently sorted query.
+func (q Query) sortSpec() string {
+	parts := make([]string, len(q.Sort))
+	for i, key := range q.Sort {
+		if key.Desc {
+			parts[i] = "-" + key.Field
+		} else {
+			parts[i] = key.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// matches reports whether car satisfies the filters of q.
+func (q Query) matches(car *models.Car) bool {
+	switch {
+	case !q.visible(car),
+		q.Make != "" && !strings.EqualFold(car.Make, q.Make),
+		q.Model != "" && !strings.EqualFold(car.Model, q.Model),
+		q.Color != "" && !strings.EqualFold(car.Color, q.Color),
+		q.Category != "" && !strings.EqualFold(car.Category, q.Category),
+		q.Year != 0 && car.Year != q.Year,
+		q.MinPrice != nil && car.Price < *q.MinPrice,
+		q.MaxPrice != nil && car.Price > *q.MaxPrice,
+		q.MinMileage != nil && car.Mileage < *q.MinMileage,
+		q.MaxMileage != nil && car.Mileage > *q.MaxMileage:
+		return false
+	}
+	return true
+}
+
+// compare orders a before b according to the sort keys of q.
+func (q Query) compare(a, b *models.Car) int {
+	for _, key := range q.Sort {
+		get := sortFields[key.Field].value
+		c := compareValues(get(a), get(b))
+		if key.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int:
+		b := b.(int)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	}
+	return 0
+}
+
+// cursor records the sort key values of the last car on a page.
+type cursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+func (q Query) encodeCursor(last *models.Car) string {
+	c := cursor{Sort: q.sortSpec()}
+	for _, key := range q.Sort {
+		c.Values = append(c.Values, sortFields[key.Field].value(last))
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor turns q.Cursor back into a car carrying the sort key values
+// of the last car of the previous page, or nil when there is no cursor.
+func (q Query) decodeCursor() (*models.Car, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	if c.Sort != q.sortSpec() || len(c.Values) != len(q.Sort) {
+		return nil, fmt.Errorf("%w: cursor belongs to a different sort", ErrInvalidCursor)
+	}
+
+	var after models.Car
+	for i, key := range q.Sort {
+		if err = setSortValue(&after, key.Field, c.Values[i]); err != nil {
+			return nil, err
+		}
+	}
+	return &after, nil
+}
+
+func setSortValue(car *models.Car, field string, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		switch field {
+		case "id":
+			car.Id = v
+		case "make":
+			car.Make = v
+		case "model":
+			car.Model = v
+		case "color":
+			car.Color = v
+		case "category":
+			car.Category = v
+		default:
+			return fmt.Errorf("%w: bad value for %v", ErrInvalidCursor, field)
+		}
+	case float64:
+		switch field {
+		case "year":
+			car.Year = int(v)
+		case "price":
+			car.Price = int(v)
+		case "mileage":
+			car.Mileage = int(v)
+		default:
+			return fmt.Errorf("%w: bad value for %v", ErrInvalidCursor, field)
+		}
+	default:
+		return fmt.Errorf("%w: bad value for %v", ErrInvalidCursor, field)
+	}
+	return nil
+}
+
+// paginate sorts the matching cars and cuts out the page following after,
+// the car decoded from the cursor of q.
+func (q Query) paginate(cars []*models.Car, after *models.Car) *Page {
+	sort.Slice(cars, func(i, j int) bool {
+		return q.compare(cars[i], cars[j]) < 0
+	})
+	start := 0
+	if after != nil {
+		start = sort.Search(len(cars), func(i int) bool {
+			return q.compare(cars[i], after) > 0
+		})
+	}
+
+	page := &Page{Cars: cars[start:]}
+	if len(page.Cars) > q.Limit {
+		page.Cars = page.Cars[:q.Limit]
+		page.Next = q.encodeCursor(page.Cars[len(page.Cars)-1])
+	}
+	return page
+}
+
+func (r repository) Query(ctx context.Context, q Query) (*Page, error) {
+	q, err := q.normalize()
+	if err != nil {
+		return nil, err
+	}
+	after, err := q.decodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.mutex.Lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.mutex.Unlock()
+
+	page := r.index.query(q, after, r.Storage)
+	for i, car := range page.Cars {
+		page.Cars[i] = car.Clone()
+	}
+	return page, nil
+}
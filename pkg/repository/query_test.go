@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/hecomp/cars/internal/models"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func intp(n int) *int {
+	return &n
+}
+
+// fillInventory saves cars with plenty of equal prices and mileages, so
+// pages split runs of ties, and archives every fifth one.
+func fillInventory(t *testing.T, repo Repository) {
+	t.Helper()
+	ctx := context.Background()
+	makes := []string{"ford", "Honda", "toyota"}
+	for n := 0; n < 40; n++ {
+		car := newCar(n%4, n)
+		car.Make = makes[n%3]
+		car.Price = n % 7 * 100
+		car.Mileage = n * 37 % 50
+		saved, err := repo.Save(ctx, car)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n%5 == 0 {
+			if _, err = repo.Archive(ctx, saved.Id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// expected answers q the slow way: every stored car, filtered and sorted.
+func expected(t *testing.T, repo Repository, q Query) []string {
+	t.Helper()
+	all, err := repo.List(context.Background(), ReadOptions{IncludeArchived: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err = q.normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cars []*models.Car
+	for _, car := range all {
+		if q.matches(car) {
+			cars = append(cars, car)
+		}
+	}
+	sort.Slice(cars, func(i, j int) bool {
+		return q.compare(cars[i], cars[j]) < 0
+	})
+	ids := make([]string, 0, len(cars))
+	for _, car := range cars {
+		ids = append(ids, car.Id)
+	}
+	return ids
+}
+
+// TestQueryPagination pages through each query and checks the pages join
+// up into the full result, whichever plan the in-memory index picks: a walk
+// of the id, price or mileage index, a narrowed set or a full scan.
+func TestQueryPagination(t *testing.T) {
+	tests := []struct {
+		name string
+		q    Query
+	}{
+		{"by id", Query{}},
+		{"by id descending", Query{Sort: []SortKey{{"id", true}}}},
+		{"by price", Query{Sort: []SortKey{{"price", false}}}},
+		{"by price descending", Query{Sort: []SortKey{{"price", true}}}},
+		{"by price from", Query{Sort: []SortKey{{"price", false}}, MinPrice: intp(200)}},
+		{"by price descending to", Query{Sort: []SortKey{{"price", true}}, MaxPrice: intp(400)}},
+		{"by mileage in range", Query{Sort: []SortKey{{"mileage", false}}, MinMileage: intp(10), MaxMileage: intp(30)}},
+		{"by mileage descending in range", Query{Sort: []SortKey{{"mileage", true}}, MinMileage: intp(10), MaxMileage: intp(30)}},
+		{"by price filtered off index", Query{Sort: []SortKey{{"price", false}}, Color: "RED", Year: 2005}},
+		{"make", Query{Make: "FORD", Sort: []SortKey{{"price", true}}}},
+		{"make and model", Query{Make: "honda", Model: "m1"}},
+		{"category by year and make", Query{Category: "sedan", Sort: []SortKey{{"year", true}, {"make", false}}}},
+		{"price range by model", Query{MinPrice: intp(100), MaxPrice: intp(300), Sort: []SortKey{{"model", false}, {"mileage", true}}}},
+		{"mileage range", Query{MaxMileage: intp(20)}},
+		{"including archived", Query{ReadOptions: ReadOptions{IncludeArchived: true}, Sort: []SortKey{{"price", false}}}},
+		{"nothing", Query{Make: "tesla"}},
+	}
+
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		fillInventory(t, repo)
+
+		for _, tt := range tests {
+			for _, limit := range []int{1, 3, 7, 100} {
+				q := tt.q
+				q.Limit = limit
+				want := expected(t, repo, q)
+
+				got := make([]string, 0)
+				for pages := 0; ; pages++ {
+					if pages > len(want) {
+						t.Fatalf("%v, limit %d: pagination does not end", tt.name, limit)
+					}
+					page, err := repo.Query(context.Background(), q)
+					if err != nil {
+						t.Fatalf("%v, limit %d: %v", tt.name, limit, err)
+					}
+					if len(page.Cars) > limit {
+						t.Errorf("%v, limit %d: page of %d cars", tt.name, limit, len(page.Cars))
+					}
+					for _, car := range page.Cars {
+						got = append(got, car.Id)
+					}
+					if page.Next == "" {
+						break
+					}
+					q.Cursor = page.Next
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%v, limit %d:\n got %v\nwant %v", tt.name, limit, got, want)
+				}
+			}
+		}
+	})
+}
+
+func TestQueryLimit(t *testing.T) {
+	tests := []struct {
+		limit, want int
+	}{
+		{0, DefaultLimit},
+		{-1, DefaultLimit},
+		{10, 10},
+		{MaxLimit, MaxLimit},
+		{MaxLimit + 1, MaxLimit},
+	}
+	for _, tt := range tests {
+		q, err := Query{Limit: tt.limit}.normalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Limit != tt.want {
+			t.Errorf("limit %d normalized to %d, want %d", tt.limit, q.Limit, tt.want)
+		}
+	}
+}
+
+func TestQuerySortKeys(t *testing.T) {
+	tests := []struct {
+		sort    string
+		want    string
+		wantErr error
+	}{
+		{"", "id", nil},
+		{"price", "price,id", nil},
+		{"-price", "-price,-id", nil},
+		{" -price , year ", "-price,year,id", nil},
+		{"year,-price", "year,-price,-id", nil},
+		{"-id,price", "-id", nil},
+		{"color,id", "color,id", nil},
+		{"weight", "", ErrInvalidSort},
+		{"price,-", "", ErrInvalidSort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			keys, err := ParseSort(tt.sort)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseSort error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			q, err := Query{Sort: keys}.normalize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := q.sortSpec(); got != tt.want {
+				t.Errorf("sorted by %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryRejectsBadCursors(t *testing.T) {
+	repo, err := NewRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillInventory(t, repo)
+	ctx := context.Background()
+	byPrice := Query{Sort: []SortKey{{"price", false}}, Limit: 2}
+	page, err := repo.Query(ctx, byPrice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+	}{
+		{"not base64", Query{Cursor: "!!!"}},
+		{"not json", Query{Cursor: "bm90IGpzb24"}},
+		{"other sort", Query{Sort: []SortKey{{"price", true}}, Cursor: page.Next}},
+		{"other field", Query{Sort: []SortKey{{"mileage", false}}, Cursor: page.Next}},
+		{"no sort", Query{Cursor: page.Next}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := repo.Query(ctx, tt.q); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("error = %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
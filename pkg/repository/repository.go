@@ -1,79 +1,384 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 	"github.com/hecomp/cars/internal/models"
 	"github.com/hecomp/cars/pkg/utils"
-	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned, wrapped, when the requested car does not exist.
+	ErrNotFound = NewError(KindNotFound, "car_not_found", "car not found")
+	// ErrVersionMismatch is returned, wrapped, when an update was made
+	// against a version of the car that is no longer current.
+	ErrVersionMismatch = NewError(KindPrecondition, "version_mismatch", "car version mismatch")
+	// ErrDuplicateCar is returned, wrapped, when a car is saved with the id
+	// of a stored car.
+	ErrDuplicateCar = NewError(KindConflict, "duplicate_car", "duplicate car")
+	// ErrDuplicateVIN is returned, wrapped, when a car is saved with a VIN
+	// already held by another car, archived or not.
+	ErrDuplicateVIN = NewError(KindConflict, "duplicate_vin", "duplicate vin")
 )
 
 type carsDB struct {
 	Storage map[string]*models.Car
 }
 
+// ReadOptions controls which cars are visible to Find and List.
+type ReadOptions struct {
+	// IncludeArchived makes soft deleted cars visible.
+	IncludeArchived bool
+}
+
+func (o ReadOptions) visible(car *models.Car) bool {
+	return o.IncludeArchived || !car.Archived()
+}
+
+// Repository stores cars. Every method but Subscribe and Close gives up with
+// the error of its context once the context is done, whether waiting for a
+// lock or for the database.
 type Repository interface {
-	Find(id string) (*models.Car, error)
-	List() []*models.Car
-	Save(user *models.Car) (*models.Car, error)
-	Update(user *models.Car) (*models.Car, error)
+	Find(ctx context.Context, id string, opts ReadOptions) (*models.Car, error)
+	// FindByVIN returns the car holding the given VIN.
+	FindByVIN(ctx context.Context, vin string, opts ReadOptions) (*models.Car, error)
+	List(ctx context.Context, opts ReadOptions) ([]*models.Car, error)
+	// Query returns one page of the cars matching q.
+	Query(ctx context.Context, q Query) (*Page, error)
+	// Iterate returns an iterator over all the cars matching q, in its
+	// order and starting after its cursor, without holding them all in
+	// memory. The limit of q is ignored, and the iteration stops when ctx is
+	// cancelled.
+	Iterate(ctx context.Context, q Query) (Iterator, error)
+	Save(ctx context.Context, user *models.Car) (*models.Car, error)
+	// Update replaces a car. When user.Version is non-zero it must match the
+	// stored version or ErrVersionMismatch is returned. The version is
+	// incremented on success.
+	Update(ctx context.Context, user *models.Car) (*models.Car, error)
+	// Archive soft deletes a car by marking it with a tombstone.
+	Archive(ctx context.Context, id string) (*models.Car, error)
+	// Restore clears the tombstone of an archived car.
+	Restore(ctx context.Context, id string) (*models.Car, error)
+	// Delete permanently removes a car, archived or not.
+	Delete(ctx context.Context, id string) error
+	// Batch applies ops in order. In AllOrNothing mode the first failing
+	// operation rolls the whole batch back and its error is returned; in
+	// BestEffort mode failures only show in the results.
+	Batch(ctx context.Context, ops []Op, mode BatchMode) ([]Result, error)
+	// Purge permanently removes cars archived before the given time and
+	// returns how many were removed.
+	Purge(ctx context.Context, before time.Time) (int, error)
+	// Subscribe registers fn to be told about every committed change.
+	Subscribe(fn Listener)
+	// Ping reports whether the repository can serve requests, giving up
+	// when ctx is done.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// mutex is a lock that can be given up on while waiting for it.
+type mutex chan struct{}
+
+// Lock acquires m, unless ctx is done first, in which case its error is
+// returned.
+func (m mutex) Lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case m <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m mutex) Unlock() {
+	<-m
 }
 
 type repository struct {
-	mutex *sync.Mutex
+	mutex mutex
 	carsDB
+	index *carIndex
+	log   *writeAheadLog
+	*listeners
+}
+
+// Option configures the in-memory repository returned by NewRepository.
+type Option func(*options)
+
+type options struct {
+	logDir       string
+	compactEvery int
 }
 
-func NewRepository() Repository {
+// WithLog makes the repository durable by appending every write to a log in
+// dir before acknowledging it, and replaying that log on startup.
+func WithLog(dir string) Option {
+	return func(o *options) {
+		o.logDir = dir
+	}
+}
+
+// WithCompactEvery sets how many log records are written before the log is
+// compacted into a snapshot. Zero disables compaction.
+func WithCompactEvery(n int) Option {
+	return func(o *options) {
+		o.compactEvery = n
+	}
+}
+
+func NewRepository(opts ...Option) (Repository, error) {
+	o := options{compactEvery: 1000}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	var db carsDB
 	db.Storage = make(map[string]*models.Car)
-	return &repository{
-		carsDB: db,
-		mutex:  &sync.Mutex{},
+	r := &repository{
+		carsDB:    db,
+		mutex:     make(mutex, 1),
+		index:     newCarIndex(),
+		listeners: &listeners{},
+	}
+	if o.logDir != "" {
+		l, err := openLog(o.logDir, o.compactEvery, db.Storage)
+		if err != nil {
+			return nil, err
+		}
+		r.log = l
+	}
+	for _, car := range db.Storage {
+		r.index.add(car)
 	}
+	return r, nil
 }
 
-func (r repository) Find(id string) (*models.Car, error) {
-	r.mutex.Lock()
+func (r repository) Find(ctx context.Context, id string, opts ReadOptions) (*models.Car, error) {
+	if err := r.mutex.Lock(ctx); err != nil {
+		return nil, err
+	}
 	defer r.mutex.Unlock()
 
-	if _, ok := r.Storage[id]; !ok {
-		return nil, fmt.Errorf("car not found %v", id)
+	car, ok := r.Storage[id]
+	if !ok || !opts.visible(car) {
+		return nil, fmt.Errorf("%w %v", ErrNotFound, id)
 	}
-	return r.Storage[id], nil
+	return car.Clone(), nil
 }
 
-func (r repository) List() []*models.Car {
-	r.mutex.Lock()
+func (r repository) FindByVIN(ctx context.Context, vin string, opts ReadOptions) (*models.Car, error) {
+	if err := r.mutex.Lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.mutex.Unlock()
+
+	id, ok := r.index.vins[vin]
+	if !ok || vin == "" || !opts.visible(r.Storage[id]) {
+		return nil, fmt.Errorf("%w: vin %v", ErrNotFound, vin)
+	}
+	return r.Storage[id].Clone(), nil
+}
+
+func (r repository) List(ctx context.Context, opts ReadOptions) ([]*models.Car, error) {
+	if err := r.mutex.Lock(ctx); err != nil {
+		return nil, err
+	}
 	defer r.mutex.Unlock()
 
 	cars := make([]*models.Car, 0, len(r.Storage))
 	for _, car := range r.Storage {
-		cars = append(cars, car)
+		if opts.visible(car) {
+			cars = append(cars, car.Clone())
+		}
+	}
+	return cars, nil
+}
+
+func (r repository) Save(ctx context.Context, user *models.Car) (*models.Car, error) {
+	if err := r.mutex.Lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.mutex.Unlock()
+
+	if _, ok := r.Storage[user.Id]; ok {
+		return nil, fmt.Errorf("%w %v", ErrDuplicateCar, user.Id)
+	}
+	if _, ok := r.index.vins[user.VIN]; ok && user.VIN != "" {
+		return nil, fmt.Errorf("%w %v", ErrDuplicateVIN, user.VIN)
+	}
+	now := time.Now().UTC()
+	user.Id = utils.GenId(9)
+	user.CreatedAt = &now
+	user.DeletedAt = nil
+	user.Version = 1
+	return r.put(user)
+}
+
+func (r repository) Update(ctx context.Context, user *models.Car) (*models.Car, error) {
+	if err := r.mutex.Lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.mutex.Unlock()
+
+	stored, ok := r.Storage[user.Id]
+	if !ok || stored.Archived() {
+		return nil, fmt.Errorf("%w %v", ErrNotFound, user.Id)
+	}
+	if user.Version != 0 && user.Version != stored.Version {
+		return nil, fmt.Errorf("%w: car %v is at version %d", ErrVersionMismatch, user.Id, stored.Version)
+	}
+	if owner, ok := r.index.vins[user.VIN]; ok && user.VIN != "" && owner != user.Id {
+		return nil, fmt.Errorf("%w %v", ErrDuplicateVIN, user.VIN)
+	}
+	user.CreatedAt = stored.CreatedAt
+	user.DeletedAt = nil
+	user.Version = stored.Version + 1
+	return r.put(user)
+}
+
+func (r repository) Archive(ctx context.Context, id string) (*models.Car, error) {
+	if err := r.mutex.Lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.mutex.Unlock()
+
+	car, ok := r.Storage[id]
+	if !ok || car.Archived() {
+		return nil, fmt.Errorf("%w %v", ErrNotFound, id)
+	}
+	now := time.Now().UTC()
+	archived := *car
+	archived.DeletedAt = &now
+	archived.Version++
+	return r.put(&archived)
+}
+
+func (r repository) Restore(ctx context.Context, id string) (*models.Car, error) {
+	if err := r.mutex.Lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.mutex.Unlock()
+
+	car, ok := r.Storage[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %v", ErrNotFound, id)
+	}
+	if !car.Archived() {
+		return car.Clone(), nil
+	}
+	restored := *car
+	restored.DeletedAt = nil
+	restored.Version++
+	return r.put(&restored)
+}
+
+// put persists and stores a copy of car, so the caller keeps a car of its own.
+// It must be called with the mutex held.
+func (r repository) put(car *models.Car) (*models.Car, error) {
+	stored := car.Clone()
+	if err := r.persist(&logRecord{Op: opPut, Car: stored}); err != nil {
+		return nil, err
+	}
+	if old, ok := r.Storage[car.Id]; ok {
+		r.index.remove(old)
+	}
+	r.Storage[car.Id] = stored
+	r.index.add(stored)
+	r.notify(car.Id, stored)
+	r.compact()
+	return car, nil
+}
+
+// drop persists the removal of car and removes it from Storage. It must be
+// called with the mutex held.
+func (r repository) drop(car *models.Car) error {
+	if err := r.persist(&logRecord{Op: opDelete, Car: car}); err != nil {
+		return err
+	}
+	delete(r.Storage, car.Id)
+	r.index.remove(car)
+	r.notify(car.Id, nil)
+	return nil
+}
+
+func (r repository) Delete(ctx context.Context, id string) error {
+	if err := r.mutex.Lock(ctx); err != nil {
+		return err
+	}
+	defer r.mutex.Unlock()
+
+	car, ok := r.Storage[id]
+	if !ok {
+		return fmt.Errorf("%w %v", ErrNotFound, id)
+	}
+	if err := r.drop(car); err != nil {
+		return err
+	}
+	r.compact()
+
+	return nil
+}
+
+func (r repository) Purge(ctx context.Context, before time.Time) (int, error) {
+	if err := r.mutex.Lock(ctx); err != nil {
+		return 0, err
+	}
+	defer r.mutex.Unlock()
+
+	purged := 0
+	for _, car := range r.Storage {
+		if !car.Archived() || !car.DeletedAt.Before(before) {
+			continue
+		}
+		if err := r.drop(car); err != nil {
+			return purged, err
+		}
+		purged++
 	}
-	return cars
+	r.compact()
+	return purged, nil
 }
 
-func (r repository) Save(user *models.Car) (*models.Car, error) {
-	r.mutex.Lock()
+// Ping succeeds once the mutex could be acquired, so a repository stuck
+// behind a long write is not ready, and while its log can be appended to.
+func (r repository) Ping(ctx context.Context) error {
+	if err := r.mutex.Lock(ctx); err != nil {
+		return err
+	}
 	defer r.mutex.Unlock()
 
-	if _, ok := r.Storage[user.Id]; !ok {
-		user.Id = utils.GenId(9)
-		r.Storage[user.Id] = user
-	} else {
-		return nil, fmt.Errorf("duplicate car %v", user)
+	if r.log == nil {
+		return nil
 	}
-	return user, nil
+	return r.log.check()
 }
 
-func (r repository) Update(user *models.Car) (*models.Car, error) {
-	r.mutex.Lock()
+func (r repository) Close() error {
+	r.mutex.Lock(context.Background())
 	defer r.mutex.Unlock()
 
-	if _, ok := r.Storage[user.Id]; !ok {
-		return nil, fmt.Errorf("car not found %v", user)
+	if r.log == nil {
+		return nil
 	}
-	r.Storage[user.Id] = user
+	return r.log.close()
+}
 
-	return r.Storage[user.Id], nil
+// persist appends rec to the write-ahead log, if one is configured. It must
+// be called with the mutex held and before the change is applied to Storage.
+func (r repository) persist(rec *logRecord) error {
+	if r.log == nil {
+		return nil
+	}
+	return r.log.append(rec)
+}
+
+func (r repository) compact() {
+	if r.log == nil {
+		return
+	}
+	r.log.maybeCompact(r.Storage)
 }
@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+const tampered = "tampered"
+
+// tamper scribbles over every field of car a caller could reach, including
+// the times behind its pointers.
+func tamper(car *models.Car) {
+	if car == nil {
+		return
+	}
+	car.Make, car.Model, car.Color = tampered, tampered, tampered
+	car.Price, car.Mileage, car.Version = -1, -1, -1
+	if car.CreatedAt != nil {
+		*car.CreatedAt = time.Time{}
+	}
+	if car.DeletedAt != nil {
+		*car.DeletedAt = time.Time{}
+	}
+}
+
+func newCar(owner, n int) *models.Car {
+	return &models.Car{
+		Make:     "ford",
+		Model:    fmt.Sprintf("m%d", owner),
+		Color:    "red",
+		Category: "Sedan",
+		Year:     2000 + n%20,
+		Price:    n,
+		Mileage:  n,
+	}
+}
+
+// TestConcurrentCallersCannotCorruptStoredCars has writers update and
+// archive the cars they own while readers list and query them all, every
+// one of them tampering with the cars they pass in and get back. The stored
+// cars must end up exactly as the writers last wrote them.
+func TestConcurrentCallersCannotCorruptStoredCars(t *testing.T) {
+	const (
+		writers = 8
+		owned   = 10
+		rounds  = 20
+		readers = 4
+	)
+	ctx := context.Background()
+	repo, err := NewRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make([]map[string]models.Car, writers)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			mine := map[string]models.Car{}
+			for n := 0; n < owned; n++ {
+				car, err := repo.Save(ctx, newCar(w, n))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mine[car.Id] = *car.Clone()
+				tamper(car)
+			}
+			for round := 0; round < rounds; round++ {
+				for id, stored := range mine {
+					in := newCar(w, round)
+					in.Id = id
+					var (
+						out *models.Car
+						err error
+					)
+					if round%2 == 0 {
+						out, err = repo.Update(ctx, in)
+					} else {
+						var results []Result
+						results, err = repo.Batch(ctx, []Op{{Kind: OpUpdate, Car: in}}, AllOrNothing)
+						if err == nil {
+							out = results[0].Car
+						}
+					}
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if out.Version != stored.Version+1 {
+						t.Errorf("car %v went from version %d to %d", id, stored.Version, out.Version)
+					}
+					mine[id] = *out.Clone()
+					tamper(in)
+					tamper(out)
+
+					found, err := repo.Find(ctx, id, ReadOptions{})
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					tamper(found)
+				}
+			}
+			for id := range mine {
+				archived, err := repo.Archive(ctx, id)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mine[id] = *archived.Clone()
+				tamper(archived)
+				break
+			}
+			want[w] = mine
+		}(w)
+	}
+
+	done := make(chan struct{})
+	var readersWG sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		readersWG.Add(1)
+		go func() {
+			defer readersWG.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				cars, err := repo.List(ctx, ReadOptions{IncludeArchived: true})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for _, car := range cars {
+					tamper(car)
+				}
+				page, err := repo.Query(ctx, Query{Make: "ford", Sort: []SortKey{{Field: "price"}}, Limit: 25})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for _, car := range page.Cars {
+					tamper(car)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	readersWG.Wait()
+
+	for w, mine := range want {
+		for id, expected := range mine {
+			got, err := repo.Find(ctx, id, ReadOptions{IncludeArchived: true})
+			if err != nil {
+				t.Fatalf("writer %d: %v", w, err)
+			}
+			if got.Make != expected.Make || got.Model != expected.Model || got.Price != expected.Price ||
+				got.Mileage != expected.Mileage || got.Version != expected.Version {
+				t.Errorf("car %v is %+v, want %+v", id, *got, expected)
+			}
+			if got.CreatedAt == nil || !got.CreatedAt.Equal(*expected.CreatedAt) {
+				t.Errorf("car %v was created at %v, want %v", id, got.CreatedAt, expected.CreatedAt)
+			}
+			if (got.DeletedAt == nil) != (expected.DeletedAt == nil) ||
+				got.DeletedAt != nil && !got.DeletedAt.Equal(*expected.DeletedAt) {
+				t.Errorf("car %v was archived at %v, want %v", id, got.DeletedAt, expected.DeletedAt)
+			}
+		}
+	}
+}
+
+// TestListenersGetCopies checks that a listener tampering with the car it is
+// told about leaves the stored car alone.
+func TestListenersGetCopies(t *testing.T) {
+	ctx := context.Background()
+	repo, err := NewRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Subscribe(func(e Event) {
+		tamper(e.Car)
+	})
+
+	car, err := repo.Save(ctx, newCar(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.Find(ctx, car.Id, ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Make == tampered || got.CreatedAt.IsZero() {
+		t.Errorf("stored car was changed by a listener: %+v", *got)
+	}
+}
@@ -0,0 +1,613 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/utils"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// migrations holds the schema changes applied in order on startup. The index
+// of the last applied migration plus one is kept in PRAGMA user_version, so
+// new entries must only ever be appended.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS cars (
+		id       TEXT PRIMARY KEY,
+		make     TEXT NOT NULL DEFAULT '',
+		model    TEXT NOT NULL DEFAULT '',
+		package  TEXT NOT NULL DEFAULT '',
+		color    TEXT NOT NULL DEFAULT '',
+		year     INTEGER NOT NULL DEFAULT 0,
+		category TEXT NOT NULL DEFAULT '',
+		mileage  INTEGER NOT NULL DEFAULT 0,
+		price    INTEGER NOT NULL DEFAULT 0
+	)`,
+	// deleted_at holds the archive time in unix nanoseconds, NULL for live cars.
+	`ALTER TABLE cars ADD COLUMN deleted_at INTEGER`,
+	`CREATE INDEX IF NOT EXISTS cars_deleted_at ON cars (deleted_at)`,
+	`ALTER TABLE cars ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	`CREATE INDEX IF NOT EXISTS cars_make ON cars (make COLLATE NOCASE)`,
+	`CREATE INDEX IF NOT EXISTS cars_category ON cars (category COLLATE NOCASE)`,
+	`CREATE INDEX IF NOT EXISTS cars_year ON cars (year)`,
+	`CREATE INDEX IF NOT EXISTS cars_price ON cars (price)`,
+	`CREATE INDEX IF NOT EXISTS cars_mileage ON cars (mileage)`,
+	// vin is NULL for cars without one, as NULLs never collide in a unique
+	// index.
+	`ALTER TABLE cars ADD COLUMN vin TEXT`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS cars_vin ON cars (vin)`,
+	// created_at holds the listing time in unix nanoseconds, NULL for cars
+	// stored before it was recorded.
+	`ALTER TABLE cars ADD COLUMN created_at INTEGER`,
+}
+
+const carColumns = `id, make, model, package, color, year, category, mileage, price, deleted_at, version, vin, created_at`
+
+type sqliteRepository struct {
+	db *sql.DB
+	*listeners
+}
+
+// NewSQLiteRepository opens (or creates) the SQLite database at path and
+// brings its schema up to date. Transactions take the write lock when they
+// begin, so that one reading before it writes waits for other writers
+// rather than failing with SQLITE_BUSY when it tries to write.
+func NewSQLiteRepository(path string) (Repository, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite %v: %w", path, err)
+	}
+	if err = migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteRepository{db: db, listeners: &listeners{}}, nil
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCar(row scanner) (*models.Car, error) {
+	var (
+		car       models.Car
+		deletedAt sql.NullInt64
+		vin       sql.NullString
+		createdAt sql.NullInt64
+	)
+	err := row.Scan(&car.Id, &car.Make, &car.Model, &car.Package, &car.Color,
+		&car.Year, &car.Category, &car.Mileage, &car.Price, &deletedAt, &car.Version, &vin, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	car.VIN = vin.String
+	car.DeletedAt = nullTime(deletedAt)
+	car.CreatedAt = nullTime(createdAt)
+	return &car, nil
+}
+
+// nullTime reads a time stored in unix nanoseconds, nil when it is NULL.
+func nullTime(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := time.Unix(0, n.Int64).UTC()
+	return &t
+}
+
+// nullVIN stores an empty VIN as NULL so cars without one do not collide.
+func nullVIN(vin string) sql.NullString {
+	return sql.NullString{String: vin, Valid: vin != ""}
+}
+
+// vinConflict translates a violation of the unique VIN index into
+// ErrDuplicateVIN.
+func vinConflict(err error, vin string) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE &&
+		strings.Contains(sqliteErr.Error(), "cars.vin") {
+		return fmt.Errorf("%w %v", ErrDuplicateVIN, vin)
+	}
+	return err
+}
+
+// visibility returns the WHERE clause fragment hiding archived cars unless
+// opts asks for them.
+func visibility(opts ReadOptions) string {
+	if opts.IncludeArchived {
+		return `1 = 1`
+	}
+	return `deleted_at IS NULL`
+}
+
+// querier is what *sql.DB and *sql.Tx have in common, so single-car
+// statements can run on their own or as part of a batch.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (r sqliteRepository) Find(ctx context.Context, id string, opts ReadOptions) (*models.Car, error) {
+	return findCar(ctx, r.db, id, opts)
+}
+
+func findCar(ctx context.Context, q querier, id string, opts ReadOptions) (*models.Car, error) {
+	row := q.QueryRowContext(ctx, `SELECT `+carColumns+` FROM cars WHERE id = ? AND `+visibility(opts), id)
+	car, err := scanCar(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w %v", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return car, nil
+}
+
+func (r sqliteRepository) FindByVIN(ctx context.Context, vin string, opts ReadOptions) (*models.Car, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+carColumns+` FROM cars WHERE vin = ? AND `+visibility(opts), vin)
+	car, err := scanCar(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: vin %v", ErrNotFound, vin)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return car, nil
+}
+
+func (r sqliteRepository) List(ctx context.Context, opts ReadOptions) ([]*models.Car, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+carColumns+` FROM cars WHERE `+visibility(opts)+` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cars := make([]*models.Car, 0)
+	for rows.Next() {
+		car, err := scanCar(rows)
+		if err != nil {
+			return nil, err
+		}
+		cars = append(cars, car)
+	}
+	return cars, rows.Err()
+}
+
+func (r sqliteRepository) Query(ctx context.Context, q Query) (*Page, error) {
+	q, err := q.normalize()
+	if err != nil {
+		return nil, err
+	}
+	after, err := q.decodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, args := selectCars(q, after)
+	rows, err := r.db.QueryContext(ctx, stmt+fmt.Sprintf(` LIMIT %d`, q.Limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &Page{Cars: make([]*models.Car, 0)}
+	for rows.Next() {
+		car, err := scanCar(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Cars = append(page.Cars, car)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Cars) > q.Limit {
+		page.Cars = page.Cars[:q.Limit]
+		page.Next = q.encodeCursor(page.Cars[len(page.Cars)-1])
+	}
+	return page, nil
+}
+
+// selectCars builds the statement selecting the cars matching the filters
+// of q, in its order, that come after the car decoded from its cursor.
+func selectCars(q Query, after *models.Car) (string, []interface{}) {
+	where := []string{visibility(q.ReadOptions)}
+	var args []interface{}
+	filter := func(clause string, arg interface{}) {
+		where = append(where, clause)
+		args = append(args, arg)
+	}
+	if q.Make != "" {
+		filter(`make = ? COLLATE NOCASE`, q.Make)
+	}
+	if q.Model != "" {
+		filter(`model = ? COLLATE NOCASE`, q.Model)
+	}
+	if q.Color != "" {
+		filter(`color = ? COLLATE NOCASE`, q.Color)
+	}
+	if q.Category != "" {
+		filter(`category = ? COLLATE NOCASE`, q.Category)
+	}
+	if q.Year != 0 {
+		filter(`year = ?`, q.Year)
+	}
+	if q.MinPrice != nil {
+		filter(`price >= ?`, *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		filter(`price <= ?`, *q.MaxPrice)
+	}
+	if q.MinMileage != nil {
+		filter(`mileage >= ?`, *q.MinMileage)
+	}
+	if q.MaxMileage != nil {
+		filter(`mileage <= ?`, *q.MaxMileage)
+	}
+
+	// Keyset pagination: a row comes after the cursor if it ties on the
+	// first i sort keys and is past it on key i, for some i.
+	if after != nil {
+		var ors []string
+		for i, key := range q.Sort {
+			var ands []string
+			for _, prev := range q.Sort[:i] {
+				ands = append(ands, sortFields[prev.Field].column+` = ?`)
+				args = append(args, sortFields[prev.Field].value(after))
+			}
+			op := `>`
+			if key.Desc {
+				op = `<`
+			}
+			ands = append(ands, sortFields[key.Field].column+` `+op+` ?`)
+			args = append(args, sortFields[key.Field].value(after))
+			ors = append(ors, `(`+strings.Join(ands, ` AND `)+`)`)
+		}
+		where = append(where, `(`+strings.Join(ors, ` OR `)+`)`)
+	}
+
+	order := make([]string, len(q.Sort))
+	for i, key := range q.Sort {
+		order[i] = sortFields[key.Field].column
+		if key.Desc {
+			order[i] += ` DESC`
+		}
+	}
+
+	stmt := `SELECT ` + carColumns + ` FROM cars WHERE ` + strings.Join(where, ` AND `) +
+		` ORDER BY ` + strings.Join(order, `, `)
+	return stmt, args
+}
+
+// rowsIterator walks the rows of a single SQL query, which is cancelled
+// with its context.
+type rowsIterator struct {
+	rows *sql.Rows
+	car  *models.Car
+	err  error
+}
+
+func (r sqliteRepository) Iterate(ctx context.Context, q Query) (Iterator, error) {
+	q, err := q.normalize()
+	if err != nil {
+		return nil, err
+	}
+	after, err := q.decodeCursor()
+	if err != nil {
+		return nil, err
+	}
+	stmt, args := selectCars(q, after)
+	rows, err := r.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &rowsIterator{rows: rows}, nil
+}
+
+func (it *rowsIterator) Next() bool {
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+	it.car, it.err = scanCar(it.rows)
+	return it.err == nil
+}
+
+func (it *rowsIterator) Car() *models.Car {
+	return it.car
+}
+
+func (it *rowsIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+func (it *rowsIterator) Close() error {
+	return it.rows.Close()
+}
+
+func (r sqliteRepository) Save(ctx context.Context, user *models.Car) (*models.Car, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = insertCar(ctx, tx, user); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	r.notify(user.Id, user)
+	return user, nil
+}
+
+func insertCar(ctx context.Context, q querier, user *models.Car) error {
+	var exists int
+	err := q.QueryRowContext(ctx, `SELECT COUNT(1) FROM cars WHERE id = ?`, user.Id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists > 0 {
+		return fmt.Errorf("%w %v", ErrDuplicateCar, user.Id)
+	}
+
+	now := time.Now().UTC()
+	user.Id = utils.GenId(9)
+	user.CreatedAt = &now
+	user.DeletedAt = nil
+	user.Version = 1
+	_, err = q.ExecContext(ctx, `INSERT INTO cars (`+carColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULL, 1, ?, ?)`,
+		user.Id, user.Make, user.Model, user.Package, user.Color,
+		user.Year, user.Category, user.Mileage, user.Price, nullVIN(user.VIN), now.UnixNano())
+	if err != nil {
+		return vinConflict(err, user.VIN)
+	}
+	return nil
+}
+
+func (r sqliteRepository) Update(ctx context.Context, user *models.Car) (*models.Car, error) {
+	if err := updateCar(ctx, r.db, user); err != nil {
+		return nil, err
+	}
+	r.notify(user.Id, user)
+	return user, nil
+}
+
+func updateCar(ctx context.Context, q querier, user *models.Car) error {
+	var (
+		version   int64
+		createdAt sql.NullInt64
+	)
+	err := q.QueryRowContext(ctx, `UPDATE cars SET make = ?, model = ?, package = ?, color = ?,
+		year = ?, category = ?, mileage = ?, price = ?, vin = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)
+		RETURNING version, created_at`,
+		user.Make, user.Model, user.Package, user.Color,
+		user.Year, user.Category, user.Mileage, user.Price, nullVIN(user.VIN),
+		user.Id, user.Version, user.Version).Scan(&version, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Nothing matched: either the car is gone or its version moved on.
+		stored, findErr := findCar(ctx, q, user.Id, ReadOptions{})
+		if findErr != nil {
+			return findErr
+		}
+		return fmt.Errorf("%w: car %v is at version %d", ErrVersionMismatch, user.Id, stored.Version)
+	}
+	if err != nil {
+		return vinConflict(err, user.VIN)
+	}
+	user.CreatedAt = nullTime(createdAt)
+	user.DeletedAt = nil
+	user.Version = version
+	return nil
+}
+
+func (r sqliteRepository) Archive(ctx context.Context, id string) (*models.Car, error) {
+	car, err := archiveCar(ctx, r.db, id)
+	if err != nil {
+		return nil, err
+	}
+	r.notify(id, car)
+	return car, nil
+}
+
+func archiveCar(ctx context.Context, q querier, id string) (*models.Car, error) {
+	res, err := q.ExecContext(ctx, `UPDATE cars SET deleted_at = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL`,
+		time.Now().UnixNano(), id)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%w %v", ErrNotFound, id)
+	}
+	return findCar(ctx, q, id, ReadOptions{IncludeArchived: true})
+}
+
+func (r sqliteRepository) Restore(ctx context.Context, id string) (*models.Car, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE cars SET deleted_at = NULL, version = version + 1
+		WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	car, err := r.Find(ctx, id, ReadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		r.notify(id, car)
+	}
+	return car, nil
+}
+
+func (r sqliteRepository) Delete(ctx context.Context, id string) error {
+	if err := deleteCar(ctx, r.db, id); err != nil {
+		return err
+	}
+	r.notify(id, nil)
+	return nil
+}
+
+func deleteCar(ctx context.Context, q querier, id string) error {
+	res, err := q.ExecContext(ctx, `DELETE FROM cars WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w %v", ErrNotFound, id)
+	}
+	return nil
+}
+
+// Batch runs every operation in one transaction. In BestEffort and DryRun
+// modes each operation runs under its own savepoint so a failure only undoes
+// itself, and a DryRun transaction is rolled back once all have run.
+func (r sqliteRepository) Batch(ctx context.Context, ops []Op, mode BatchMode) ([]Result, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]Result, len(ops))
+	for i, op := range ops {
+		if mode != AllOrNothing {
+			if _, err = tx.ExecContext(ctx, `SAVEPOINT op`); err != nil {
+				return nil, err
+			}
+		}
+		car, err := applyOp(ctx, tx, op)
+		results[i] = Result{Car: car, Err: err}
+		switch {
+		case err != nil && mode == AllOrNothing:
+			return abort(results), fmt.Errorf("operation %d: %w", i, err)
+		case err != nil:
+			_, err = tx.ExecContext(ctx, `ROLLBACK TO op`)
+		case mode != AllOrNothing:
+			_, err = tx.ExecContext(ctx, `RELEASE op`)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if mode == DryRun {
+		return results, nil
+	}
+	if err = tx.Commit(); err != nil {
+		return abort(results), err
+	}
+
+	for i, op := range ops {
+		switch {
+		case results[i].Err != nil:
+		case results[i].Car == nil:
+			r.notify(op.Id, nil)
+		default:
+			r.notify(results[i].Car.Id, results[i].Car)
+		}
+	}
+	return results, nil
+}
+
+func applyOp(ctx context.Context, q querier, op Op) (*models.Car, error) {
+	if err := validOp(op); err != nil {
+		return nil, err
+	}
+	switch op.Kind {
+	case OpCreate:
+		if err := insertCar(ctx, q, op.Car); err != nil {
+			return nil, err
+		}
+		return op.Car, nil
+	case OpUpdate:
+		if err := updateCar(ctx, q, op.Car); err != nil {
+			return nil, err
+		}
+		return op.Car, nil
+	default:
+		if op.Permanent {
+			return nil, deleteCar(ctx, q, op.Id)
+		}
+		return archiveCar(ctx, q, op.Id)
+	}
+}
+
+func (r sqliteRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	rows, err := r.db.QueryContext(ctx, `DELETE FROM cars WHERE deleted_at IS NOT NULL AND deleted_at < ?
+		RETURNING id`, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		r.notify(id, nil)
+	}
+	return len(ids), nil
+}
+
+func (r sqliteRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+func (r sqliteRepository) Close() error {
+	return r.db.Close()
+}
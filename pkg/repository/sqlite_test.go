@@ -0,0 +1,310 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// backends opens each kind of repository, empty, for the tests that must
+// hold for both.
+var backends = []struct {
+	name string
+	open func(t *testing.T) (Repository, error)
+}{
+	{"memory", func(t *testing.T) (Repository, error) { return NewRepository() }},
+	{"sqlite", func(t *testing.T) (Repository, error) {
+		return NewSQLiteRepository(filepath.Join(t.TempDir(), "cars.db"))
+	}},
+}
+
+// forEachBackend runs test as a subtest against each backend.
+func forEachBackend(t *testing.T, test func(t *testing.T, repo Repository)) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			repo, err := backend.open(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer repo.Close()
+			test(t, repo)
+		})
+	}
+}
+
+func TestCRUD(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		car := newCar(0, 1)
+		car.VIN = "1HGCM82633A004352"
+		saved, err := repo.Save(ctx, car)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Id == "" || saved.Version != 1 || saved.CreatedAt == nil || saved.DeletedAt != nil {
+			t.Fatalf("saved %+v", *saved)
+		}
+
+		got, err := repo.Find(ctx, saved.Id, ReadOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Make != car.Make || got.VIN != car.VIN || got.Version != 1 || !got.CreatedAt.Equal(*saved.CreatedAt) {
+			t.Errorf("found %+v, want %+v", *got, *saved)
+		}
+		if got, err = repo.FindByVIN(ctx, car.VIN, ReadOptions{}); err != nil || got.Id != saved.Id {
+			t.Errorf("find by vin = %v, %v", got, err)
+		}
+
+		update := newCar(0, 2)
+		update.Id = saved.Id
+		updated, err := repo.Update(ctx, update)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Version != 2 || updated.Price != 2 || !updated.CreatedAt.Equal(*saved.CreatedAt) {
+			t.Errorf("updated %+v", *updated)
+		}
+		if _, err = repo.FindByVIN(ctx, car.VIN, ReadOptions{}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("find by a vin the update cleared = %v, want %v", err, ErrNotFound)
+		}
+
+		if err = repo.Delete(ctx, saved.Id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repo.Find(ctx, saved.Id, ReadOptions{IncludeArchived: true}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("find after delete = %v, want %v", err, ErrNotFound)
+		}
+		if err = repo.Delete(ctx, saved.Id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("second delete = %v, want %v", err, ErrNotFound)
+		}
+		if _, err = repo.Update(ctx, update); !errors.Is(err, ErrNotFound) {
+			t.Errorf("update after delete = %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestVINConflicts(t *testing.T) {
+	const vin = "1HGCM82633A004352"
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		first := newCar(0, 1)
+		first.VIN = vin
+		if _, err := repo.Save(ctx, first); err != nil {
+			t.Fatal(err)
+		}
+		second, err := repo.Save(ctx, newCar(0, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repo.Save(ctx, newCar(0, 3)); err != nil {
+			t.Fatal("cars without a vin collide:", err)
+		}
+
+		dup := newCar(0, 4)
+		dup.VIN = vin
+		if _, err = repo.Save(ctx, dup); !errors.Is(err, ErrDuplicateVIN) {
+			t.Errorf("save = %v, want %v", err, ErrDuplicateVIN)
+		}
+		second.VIN = vin
+		if _, err = repo.Update(ctx, second); !errors.Is(err, ErrDuplicateVIN) {
+			t.Errorf("update = %v, want %v", err, ErrDuplicateVIN)
+		}
+		if got, err := repo.Find(ctx, second.Id, ReadOptions{}); err != nil || got.VIN != "" || got.Version != 1 {
+			t.Errorf("car changed by a rejected update: %+v, %v", got, err)
+		}
+
+		// The VIN stays taken while its car is archived, and is freed
+		// when it is deleted.
+		if _, err = repo.Archive(ctx, first.Id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repo.Save(ctx, dup); !errors.Is(err, ErrDuplicateVIN) {
+			t.Errorf("save over an archived car = %v, want %v", err, ErrDuplicateVIN)
+		}
+		if err = repo.Delete(ctx, first.Id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = repo.Save(ctx, dup); err != nil {
+			t.Errorf("save over a deleted car = %v", err)
+		}
+	})
+}
+
+func TestUpdateVersionMismatch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		saved, err := repo.Save(ctx, newCar(0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name    string
+			version int64
+			wantErr error
+		}{
+			{"stale", 2, ErrVersionMismatch},
+			{"current", 1, nil},
+			{"no longer current", 1, ErrVersionMismatch},
+			{"unpinned", 0, nil},
+		}
+		for _, tt := range tests {
+			update := newCar(0, 5)
+			update.Id, update.Version = saved.Id, tt.version
+			before, err := repo.Find(ctx, saved.Id, ReadOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = repo.Update(ctx, update)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%v: update = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			after, err := repo.Find(ctx, saved.Id, ReadOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := before.Version + 1; tt.wantErr == nil && (update.Version != want || after.Version != want) {
+				t.Errorf("%v: updated to version %d, stored %d, want %d", tt.name, update.Version, after.Version, want)
+			}
+			if tt.wantErr != nil && after.Version != before.Version {
+				t.Errorf("%v: rejected update moved the version to %d", tt.name, after.Version)
+			}
+		}
+
+		if _, err = repo.Archive(ctx, saved.Id); err != nil {
+			t.Fatal(err)
+		}
+		update := newCar(0, 6)
+		update.Id = saved.Id
+		if _, err = repo.Update(ctx, update); !errors.Is(err, ErrNotFound) {
+			t.Errorf("update of an archived car = %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestArchiveRestore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		saved, err := repo.Save(ctx, newCar(0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		archived, err := repo.Archive(ctx, saved.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if archived.DeletedAt == nil || archived.Version != 2 {
+			t.Errorf("archived %+v", *archived)
+		}
+		if _, err = repo.Find(ctx, saved.Id, ReadOptions{}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("find archived = %v, want %v", err, ErrNotFound)
+		}
+		if got, err := repo.Find(ctx, saved.Id, ReadOptions{IncludeArchived: true}); err != nil || got.DeletedAt == nil {
+			t.Errorf("find including archived = %+v, %v", got, err)
+		}
+		if cars, err := repo.List(ctx, ReadOptions{}); err != nil || len(cars) != 0 {
+			t.Errorf("list = %d cars, %v, want none", len(cars), err)
+		}
+		if _, err = repo.Archive(ctx, saved.Id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("second archive = %v, want %v", err, ErrNotFound)
+		}
+
+		restored, err := repo.Restore(ctx, saved.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.DeletedAt != nil || restored.Version != 3 {
+			t.Errorf("restored %+v", *restored)
+		}
+		if again, err := repo.Restore(ctx, saved.Id); err != nil || again.Version != 3 {
+			t.Errorf("restoring a live car = %+v, %v, want it unchanged", again, err)
+		}
+		if _, err = repo.Restore(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("restore missing = %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+// TestConcurrentSaves saves cars from many goroutines at once. With
+// deferred transactions SQLite fails writers that started out reading with
+// SQLITE_BUSY instead of waiting for the lock.
+func TestConcurrentSaves(t *testing.T) {
+	const (
+		writers = 8
+		saves   = 20
+	)
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		var wg sync.WaitGroup
+		errs := make(chan error, writers*saves)
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for n := 0; n < saves; n++ {
+					car := newCar(w, n)
+					car.VIN = fmt.Sprintf("VIN%02d%03d", w, n)
+					if _, err := repo.Save(ctx, car); err != nil {
+						errs <- err
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+		if cars, err := repo.List(ctx, ReadOptions{}); err != nil || len(cars) != writers*saves {
+			t.Errorf("list = %d cars, %v, want %d", len(cars), err, writers*saves)
+		}
+	})
+}
+
+// TestMigrations opens a database created before any column was added and
+// checks its cars come through the migrations intact.
+func TestMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cars.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(migrations[0] + `;
+		INSERT INTO cars (id, make, model, year, price) VALUES ('old', 'ford', 'm0', 1999, 500);
+		PRAGMA user_version = 1`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		repo, err := NewSQLiteRepository(path)
+		if err != nil {
+			t.Fatalf("open %d: %v", i+1, err)
+		}
+		car, err := repo.Find(context.Background(), "old", ReadOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if car.Make != "ford" || car.Year != 1999 || car.Price != 500 || car.Version != 1 ||
+			car.VIN != "" || car.CreatedAt != nil || car.DeletedAt != nil {
+			t.Errorf("migrated car = %+v", *car)
+		}
+
+		var version int
+		if err = repo.(*sqliteRepository).db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		if version != len(migrations) {
+			t.Errorf("schema version = %d, want %d", version, len(migrations))
+		}
+		repo.Close()
+	}
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/internal/telemetry/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+var tracer = otel.Tracer("github.com/hecomp/cars/pkg/repository")
+
+// tracedRepository wraps every call to a repository in a span, so the time
+// spent waiting for locks or the database shows up in traces.
+type tracedRepository struct {
+	Repository
+}
+
+// Traced returns repo with every call traced as a child of the span in its
+// context. An iteration is traced from Iterate until Close.
+func Traced(repo Repository) Repository {
+	return tracedRepository{repo}
+}
+
+func carID(id string) trace.SpanStartOption {
+	return trace.WithAttributes(attribute.String("car.id", id))
+}
+
+func (r tracedRepository) Find(ctx context.Context, id string, opts ReadOptions) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "Repository.Find", carID(id))
+	car, err := r.Repository.Find(ctx, id, opts)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (r tracedRepository) FindByVIN(ctx context.Context, vin string, opts ReadOptions) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "Repository.FindByVIN")
+	car, err := r.Repository.FindByVIN(ctx, vin, opts)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (r tracedRepository) List(ctx context.Context, opts ReadOptions) ([]*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "Repository.List")
+	cars, err := r.Repository.List(ctx, opts)
+	span.SetAttributes(attribute.Int("cars.count", len(cars)))
+	tracing.End(span, err)
+	return cars, err
+}
+
+func (r tracedRepository) Query(ctx context.Context, q Query) (*Page, error) {
+	ctx, span := tracer.Start(ctx, "Repository.Query",
+		trace.WithAttributes(attribute.Int("query.limit", q.Limit)))
+	page, err := r.Repository.Query(ctx, q)
+	if page != nil {
+		span.SetAttributes(attribute.Int("cars.count", len(page.Cars)))
+	}
+	tracing.End(span, err)
+	return page, err
+}
+
+func (r tracedRepository) Iterate(ctx context.Context, q Query) (Iterator, error) {
+	ctx, span := tracer.Start(ctx, "Repository.Iterate")
+	it, err := r.Repository.Iterate(ctx, q)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	return &tracedIterator{Iterator: it, span: span}, nil
+}
+
+func (r tracedRepository) Save(ctx context.Context, user *models.Car) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "Repository.Save")
+	car, err := r.Repository.Save(ctx, user)
+	if err == nil {
+		span.SetAttributes(attribute.String("car.id", car.Id))
+	}
+	tracing.End(span, err)
+	return car, err
+}
+
+func (r tracedRepository) Update(ctx context.Context, user *models.Car) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "Repository.Update", carID(user.Id))
+	car, err := r.Repository.Update(ctx, user)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (r tracedRepository) Archive(ctx context.Context, id string) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "Repository.Archive", carID(id))
+	car, err := r.Repository.Archive(ctx, id)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (r tracedRepository) Restore(ctx context.Context, id string) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "Repository.Restore", carID(id))
+	car, err := r.Repository.Restore(ctx, id)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (r tracedRepository) Delete(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "Repository.Delete", carID(id))
+	err := r.Repository.Delete(ctx, id)
+	tracing.End(span, err)
+	return err
+}
+
+func (r tracedRepository) Batch(ctx context.Context, ops []Op, mode BatchMode) ([]Result, error) {
+	ctx, span := tracer.Start(ctx, "Repository.Batch",
+		trace.WithAttributes(attribute.Int("batch.size", len(ops)), attribute.Int("batch.mode", int(mode))))
+	results, err := r.Repository.Batch(ctx, ops, mode)
+	tracing.End(span, err)
+	return results, err
+}
+
+func (r tracedRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	ctx, span := tracer.Start(ctx, "Repository.Purge")
+	n, err := r.Repository.Purge(ctx, before)
+	span.SetAttributes(attribute.Int("cars.count", n))
+	tracing.End(span, err)
+	return n, err
+}
+
+// tracedIterator ends the span of an iteration when it is closed.
+type tracedIterator struct {
+	Iterator
+	span trace.Span
+	n    int
+}
+
+func (it *tracedIterator) Next() bool {
+	if !it.Iterator.Next() {
+		return false
+	}
+	it.n++
+	return true
+}
+
+func (it *tracedIterator) Close() error {
+	it.span.SetAttributes(attribute.Int("cars.count", it.n))
+	tracing.End(it.span, it.Iterator.Err())
+	return it.Iterator.Close()
+}
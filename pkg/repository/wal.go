@@ -0,0 +1,353 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	logFileName      = "cars.log"
+	snapshotFileName = "cars.snapshot"
+
+	// recordHeaderSize is the length prefix, its CRC32 and the CRC32 of the
+	// payload. The length is checked on its own so that a damaged one is
+	// told apart from a record cut short by the end of the log.
+	recordHeaderSize = 12
+	// maxRecordSize bounds the payload of a record, so a corrupt length
+	// can't make replay allocate gigabytes.
+	maxRecordSize = 64 << 20
+
+	opPut    = "put"
+	opDelete = "delete"
+	opBatch  = "batch"
+)
+
+var (
+	// errTornRecord is a record cut short by the end of the log.
+	errTornRecord = errors.New("torn log record")
+	// errCorruptRecord is a record whose length or payload doesn't check
+	// out.
+	errCorruptRecord = errors.New("corrupt log record")
+)
+
+// logFile is the file a log is written to, an *os.File outside of tests.
+type logFile interface {
+	io.ReadWriteSeeker
+	Truncate(size int64) error
+	Sync() error
+	Stat() (os.FileInfo, error)
+	Close() error
+}
+
+// logRecord is a single mutation appended to the write-ahead log. Records
+// carry the full car so replaying one twice is harmless. A batch record
+// groups the records of a batch so they are replayed all or not at all.
+type logRecord struct {
+	Op    string       `json:"op"`
+	Car   *models.Car  `json:"car,omitempty"`
+	Batch []*logRecord `json:"batch,omitempty"`
+}
+
+// writeAheadLog makes the in-memory map durable. Every mutation is appended
+// and fsync'd before it is applied, and once compactEvery records have been
+// written the whole map is snapshotted and the log is truncated.
+type writeAheadLog struct {
+	dir          string
+	file         logFile
+	records      int
+	compactEvery int
+	// failed is set when a failed append could not be undone, after which
+	// nothing more is appended so no write lands behind a torn record.
+	failed error
+}
+
+// openLog loads the snapshot and replays the log found in dir into storage.
+// A torn or corrupt record at the tail of the log, as left behind by a crash
+// mid-write, is truncated away.
+func openLog(dir string, compactEvery int, storage map[string]*models.Car) (*writeAheadLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create log dir %v: %w", dir, err)
+	}
+	if err := loadSnapshot(filepath.Join(dir, snapshotFileName), storage); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open log: %w", err)
+	}
+	l := &writeAheadLog{dir: dir, file: file, compactEvery: compactEvery}
+	if err = l.replay(storage); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func loadSnapshot(path string, storage map[string]*models.Car) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	var cars []*models.Car
+	if err = json.Unmarshal(data, &cars); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	for _, car := range cars {
+		storage[car.Id] = car
+	}
+	return nil
+}
+
+// replay applies the records of the log to storage. A torn or corrupt last
+// record is what a crash mid-append leaves behind and is truncated away, but
+// a corrupt record followed by others, or one whose length can't be trusted,
+// means the log itself is damaged, and replay fails rather than drop the
+// writes after it.
+func (l *writeAheadLog) replay(storage map[string]*models.Car) error {
+	info, err := l.file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat log: %w", err)
+	}
+	size := info.Size()
+	reader := bufio.NewReader(l.file)
+	var offset int64
+	for {
+		rec, n, err := readRecord(reader, size-offset)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errCorruptRecord) && (n == 0 || offset+n < size) {
+			return fmt.Errorf("replay log: %w at offset %d", err, offset)
+		}
+		if errors.Is(err, errTornRecord) || errors.Is(err, errCorruptRecord) {
+			if err = l.file.Truncate(offset); err != nil {
+				return fmt.Errorf("truncate torn log record: %w", err)
+			}
+			if err = l.file.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("replay log: %w", err)
+		}
+		apply(storage, rec)
+		offset += n
+		l.records++
+	}
+	_, err = l.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// readRecord reads the next record from r, which has remaining bytes left.
+// The length of a corrupt record is returned along with errCorruptRecord, or
+// 0 when the length itself is corrupt or too large. A record is only torn
+// when its header or its payload is cut short by the end of the log, not
+// when a corrupt length points past it.
+func readRecord(r io.Reader, remaining int64) (*logRecord, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, errTornRecord
+		}
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if crc32.ChecksumIEEE(header[0:4]) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errCorruptRecord
+	}
+	sum := binary.BigEndian.Uint32(header[8:12])
+	if size > maxRecordSize {
+		return nil, 0, errCorruptRecord
+	}
+	n := int64(recordHeaderSize) + int64(size)
+	if n > remaining {
+		return nil, 0, errTornRecord
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, errTornRecord
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, n, errCorruptRecord
+	}
+	var rec logRecord
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return nil, n, errCorruptRecord
+	}
+	return &rec, n, nil
+}
+
+func apply(storage map[string]*models.Car, rec *logRecord) {
+	switch rec.Op {
+	case opPut:
+		storage[rec.Car.Id] = rec.Car
+	case opDelete:
+		delete(storage, rec.Car.Id)
+	case opBatch:
+		for _, r := range rec.Batch {
+			apply(storage, r)
+		}
+	}
+}
+
+// append writes rec to the log and waits for it to reach the disk. When that
+// fails, whatever part of rec was written is cut off again.
+func (l *writeAheadLog) append(rec *logRecord) error {
+	if l.failed != nil {
+		return fmt.Errorf("append log: log unusable since a failed append: %w", l.failed)
+	}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("append log: record of %d bytes exceeds %d", len(payload), maxRecordSize)
+	}
+	buf := make([]byte, recordHeaderSize+len(payload))
+	putRecordHeader(buf, uint32(len(payload)), crc32.ChecksumIEEE(payload))
+	copy(buf[recordHeaderSize:], payload)
+
+	offset, err := l.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("append log: %w", err)
+	}
+	if _, err = l.file.Write(buf); err != nil {
+		return l.rollback(offset, fmt.Errorf("append log: %w", err))
+	}
+	if err = l.file.Sync(); err != nil {
+		return l.rollback(offset, fmt.Errorf("sync log: %w", err))
+	}
+	l.records++
+	return nil
+}
+
+// putRecordHeader writes the header of a record of size bytes whose payload
+// sums to sum.
+func putRecordHeader(buf []byte, size, sum uint32) {
+	binary.BigEndian.PutUint32(buf[0:4], size)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[0:4]))
+	binary.BigEndian.PutUint32(buf[8:12], sum)
+}
+
+// rollback truncates the log back to offset after an append failed with err.
+// If that fails too the log is marked failed.
+func (l *writeAheadLog) rollback(offset int64, err error) error {
+	if terr := l.undo(offset); terr != nil {
+		l.failed = err
+		return fmt.Errorf("%w; undoing it: %v", err, terr)
+	}
+	return err
+}
+
+func (l *writeAheadLog) undo(offset int64) error {
+	if err := l.file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// check returns why the log can't be appended to, if it can't.
+func (l *writeAheadLog) check() error {
+	return l.failed
+}
+
+// maybeCompact snapshots storage and truncates the log once enough records
+// have accumulated. A failed compaction leaves the log intact, so it is
+// simply retried after the next write.
+func (l *writeAheadLog) maybeCompact(storage map[string]*models.Car) {
+	if l.compactEvery <= 0 || l.records < l.compactEvery {
+		return
+	}
+	if err := l.compact(storage); err == nil {
+		l.records = 0
+	}
+}
+
+func (l *writeAheadLog) compact(storage map[string]*models.Car) error {
+	cars := make([]*models.Car, 0, len(storage))
+	for _, car := range storage {
+		cars = append(cars, car)
+	}
+	data, err := json.Marshal(cars)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(l.dir, snapshotFileName+".tmp")
+	if err = writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(l.dir, snapshotFileName)); err != nil {
+		return err
+	}
+	if err = syncDir(l.dir); err != nil {
+		return err
+	}
+
+	// Records already in the snapshot may be replayed again if we crash
+	// before the truncate lands; that is safe because puts and deletes are
+	// idempotent.
+	if err = l.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err = l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (l *writeAheadLog) close() error {
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
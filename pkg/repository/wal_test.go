@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"errors"
+	"github.com/hecomp/cars/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func putRecord(id string) *logRecord {
+	return &logRecord{Op: opPut, Car: &models.Car{Id: id, Make: "ford"}}
+}
+
+// writeLog appends a record per id to a fresh log in a temporary directory
+// and returns the directory.
+func writeLog(t *testing.T, ids ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	l, err := openLog(dir, 0, map[string]*models.Car{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if err = l.append(putRecord(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = l.close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func logSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+// damage applies fn to the bytes of the log in dir.
+func damage(t *testing.T, dir string, fn func([]byte) []byte) {
+	t.Helper()
+	path := filepath.Join(dir, logFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, fn(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func replayed(t *testing.T, dir string) (map[string]*models.Car, error) {
+	t.Helper()
+	storage := map[string]*models.Car{}
+	l, err := openLog(dir, 0, storage)
+	if err != nil {
+		return nil, err
+	}
+	l.close()
+	return storage, nil
+}
+
+func TestReplayTruncatesTail(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(data []byte) []byte
+		want   []string
+	}{
+		{"torn header", func(data []byte) []byte {
+			return append(data, 0, 0, 0)
+		}, []string{"a", "b", "c"}},
+		{"torn payload", func(data []byte) []byte {
+			return data[:len(data)-3]
+		}, []string{"a", "b"}},
+		{"corrupt last payload", func(data []byte) []byte {
+			data[len(data)-2] ^= 0xff
+			return data
+		}, []string{"a", "b"}},
+		{"header without its payload", func(data []byte) []byte {
+			header := make([]byte, recordHeaderSize)
+			putRecordHeader(header, 1000, 0)
+			return append(data, header...)
+		}, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeLog(t, "a", "b", "c")
+			damage(t, dir, tt.damage)
+
+			storage, err := replayed(t, dir)
+			if err != nil {
+				t.Fatalf("replay: %v", err)
+			}
+			if len(storage) != len(tt.want) {
+				t.Errorf("replayed %d cars, want %v", len(storage), tt.want)
+			}
+			for _, id := range tt.want {
+				if _, ok := storage[id]; !ok {
+					t.Errorf("car %v was not replayed", id)
+				}
+			}
+			if got, want := logSize(t, dir), logSize(t, writeLog(t, tt.want...)); got != want {
+				t.Errorf("log is %d bytes after truncation, want %d", got, want)
+			}
+		})
+	}
+}
+
+// TestReplayFailsOnDamageMidLog damages the first of three records. Replay
+// must fail rather than truncate the log and lose the records after it, even
+// when the damaged length points past the end of the log.
+func TestReplayFailsOnDamageMidLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(data []byte) []byte
+	}{
+		{"corrupt payload", func(data []byte) []byte {
+			data[recordHeaderSize+2] ^= 0xff
+			return data
+		}},
+		{"length past the end", func(data []byte) []byte {
+			data[1] ^= 0x10
+			return data
+		}},
+		{"shorter length", func(data []byte) []byte {
+			data[3] ^= 0x01
+			return data
+		}},
+		{"length checksum", func(data []byte) []byte {
+			data[5] ^= 0xff
+			return data
+		}},
+		{"length too large", func(data []byte) []byte {
+			putRecordHeader(data, maxRecordSize+1, 0)
+			return data
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeLog(t, "a", "b", "c")
+			damage(t, dir, tt.damage)
+			size := logSize(t, dir)
+
+			_, err := replayed(t, dir)
+			if !errors.Is(err, errCorruptRecord) || !strings.Contains(err.Error(), "offset 0") {
+				t.Fatalf("replay = %v, want a corrupt record at offset 0", err)
+			}
+			if logSize(t, dir) != size {
+				t.Error("a damaged log was truncated")
+			}
+		})
+	}
+}
+
+// faultyFile fails the next write after writing half of it, and the next
+// sync or truncate, when told to.
+type faultyFile struct {
+	*os.File
+	failWrite, failSync, failTruncate bool
+}
+
+var errInjected = errors.New("injected failure")
+
+func (f *faultyFile) Write(b []byte) (int, error) {
+	if f.failWrite {
+		f.failWrite = false
+		n, _ := f.File.Write(b[:len(b)/2])
+		return n, errInjected
+	}
+	return f.File.Write(b)
+}
+
+func (f *faultyFile) Sync() error {
+	if f.failSync {
+		f.failSync = false
+		return errInjected
+	}
+	return f.File.Sync()
+}
+
+func (f *faultyFile) Truncate(size int64) error {
+	if f.failTruncate {
+		return errInjected
+	}
+	return f.File.Truncate(size)
+}
+
+func TestFailedAppendIsRolledBack(t *testing.T) {
+	tests := []struct {
+		name string
+		file faultyFile
+	}{
+		{"write", faultyFile{failWrite: true}},
+		{"sync", faultyFile{failSync: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeLog(t, "a")
+			l, err := openLog(dir, 0, map[string]*models.Car{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := tt.file
+			f.File = l.file.(*os.File)
+			l.file = &f
+
+			if err = l.append(putRecord("lost")); !errors.Is(err, errInjected) {
+				t.Fatalf("append = %v, want %v", err, errInjected)
+			}
+			if err = l.append(putRecord("b")); err != nil {
+				t.Fatalf("append after a rolled back failure: %v", err)
+			}
+			l.close()
+
+			storage, err := replayed(t, dir)
+			if err != nil {
+				t.Fatalf("replay: %v", err)
+			}
+			if _, ok := storage["b"]; !ok || len(storage) != 2 {
+				t.Errorf("replayed %v, want a and b", storage)
+			}
+		})
+	}
+}
+
+func TestAppendRefusedWhenRollbackFails(t *testing.T) {
+	dir := writeLog(t, "a")
+	l, err := openLog(dir, 0, map[string]*models.Car{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.file = &faultyFile{File: l.file.(*os.File), failWrite: true, failTruncate: true}
+
+	if err = l.append(putRecord("torn")); !errors.Is(err, errInjected) {
+		t.Fatalf("append = %v, want %v", err, errInjected)
+	}
+	if l.check() == nil {
+		t.Error("check passes on a log that could not be rolled back")
+	}
+	if err = l.append(putRecord("b")); err == nil {
+		t.Fatal("append behind a torn record succeeded")
+	}
+	l.close()
+
+	storage, err := replayed(t, dir)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(storage) != 1 {
+		t.Errorf("replayed %v, want only a", storage)
+	}
+}
@@ -0,0 +1,360 @@
+package search
+
+import (
+	"context"
+	"github.com/google/btree"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Relative weight of a query term matching exactly, as the prefix of an
+// indexed term, or within the allowed edit distance of one.
+const (
+	exactWeight  = 1.0
+	prefixWeight = 0.6
+	fuzzyWeight  = 0.4
+
+	// minPrefixLen is the shortest query term expanded to prefix matches.
+	minPrefixLen = 2
+)
+
+// fieldBoosts weights matches by the field they were found in.
+var fieldBoosts = []struct {
+	boost float64
+	value func(*models.Car) string
+}{
+	{3, func(c *models.Car) string { return c.Make }},
+	{3, func(c *models.Car) string { return c.Model }},
+	{1.5, func(c *models.Car) string { return c.Package }},
+	{1, func(c *models.Car) string { return c.Color }},
+	{1, func(c *models.Car) string { return c.Category }},
+	{1, func(c *models.Car) string {
+		if c.Year == 0 {
+			return ""
+		}
+		return strconv.Itoa(c.Year)
+	}},
+}
+
+// Hit is a single search result.
+type Hit struct {
+	Id    string
+	Score float64
+	// Matched is the number of query terms the car matched.
+	Matched int
+}
+
+type document struct {
+	version int64
+	// terms maps each indexed term to its field boost in this document.
+	terms map[string]float64
+}
+
+// Index is an inverted index over the make, model, package, color, category
+// and year of the live cars. It is safe for concurrent use.
+type Index struct {
+	mutex    sync.RWMutex
+	docs     map[string]*document
+	postings map[string]map[string]float64
+	// terms holds the indexed terms in order, for prefix matches, and
+	// lengths the terms of each length in runes, for typo matches.
+	terms   *btree.BTreeG[string]
+	lengths map[int]map[string]bool
+	// removed holds the version each removed car was removed at, so that
+	// an older version of it arriving late is not indexed again.
+	removed map[string]int64
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]float64),
+		terms:    btree.NewG(32, func(a, b string) bool { return a < b }),
+		lengths:  make(map[int]map[string]bool),
+		removed:  make(map[string]int64),
+	}
+}
+
+// Build creates an index over the live cars of repo and keeps it in sync
+// with every later write.
+func Build(ctx context.Context, repo repository.Repository) (*Index, error) {
+	ix := NewIndex()
+	// Subscribe before loading so no write is missed; versions make
+	// applying a change twice harmless, and keep a car listed before it was
+	// removed from coming back.
+	repo.Subscribe(ix.Apply)
+	cars, err := repo.List(ctx, repository.ReadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, car := range cars {
+		ix.Put(car)
+	}
+	return ix, nil
+}
+
+// Apply updates the index with a change committed to the repository.
+func (ix *Index) Apply(e repository.Event) {
+	switch {
+	case e.Car == nil:
+		ix.Remove(e.Id)
+	case e.Car.Archived():
+		ix.remove(e.Id, e.Car.Version)
+	default:
+		ix.Put(e.Car)
+	}
+}
+
+// Put indexes car, replacing any older version of it. A car removed at its
+// version or a later one stays removed.
+func (ix *Index) Put(car *models.Car) {
+	terms := make(map[string]float64)
+	for _, field := range fieldBoosts {
+		for _, term := range Tokenize(field.value(car)) {
+			if field.boost > terms[term] {
+				terms[term] = field.boost
+			}
+		}
+	}
+
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+
+	if version, ok := ix.removed[car.Id]; ok {
+		if version >= car.Version {
+			return
+		}
+		delete(ix.removed, car.Id)
+	}
+	if old, ok := ix.docs[car.Id]; ok {
+		if old.version > car.Version {
+			return
+		}
+		ix.unlink(car.Id, old)
+	}
+	doc := &document{version: car.Version, terms: terms}
+	ix.docs[car.Id] = doc
+	for term, boost := range terms {
+		posting, ok := ix.postings[term]
+		if !ok {
+			posting = make(map[string]float64)
+			ix.postings[term] = posting
+			ix.link(term)
+		}
+		posting[car.Id] = boost
+	}
+}
+
+// Remove drops the car with the given id from the index for good.
+func (ix *Index) Remove(id string) {
+	ix.remove(id, math.MaxInt64)
+}
+
+// remove drops the car unless the index already holds a version newer than
+// the one being removed, and keeps it from being indexed again at the
+// version removed or an older one.
+func (ix *Index) remove(id string, version int64) {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+
+	doc, ok := ix.docs[id]
+	if ok && doc.version > version {
+		return
+	}
+	if ok {
+		ix.unlink(id, doc)
+		delete(ix.docs, id)
+	}
+	if version > ix.removed[id] {
+		ix.removed[id] = version
+	}
+}
+
+// link adds a newly indexed term to terms and lengths.
+func (ix *Index) link(term string) {
+	ix.terms.ReplaceOrInsert(term)
+	n := utf8.RuneCountInString(term)
+	if ix.lengths[n] == nil {
+		ix.lengths[n] = make(map[string]bool)
+	}
+	ix.lengths[n][term] = true
+}
+
+func (ix *Index) unlink(id string, doc *document) {
+	for term := range doc.terms {
+		posting := ix.postings[term]
+		delete(posting, id)
+		if len(posting) == 0 {
+			delete(ix.postings, term)
+			ix.terms.Delete(term)
+			n := utf8.RuneCountInString(term)
+			delete(ix.lengths[n], term)
+			if len(ix.lengths[n]) == 0 {
+				delete(ix.lengths, n)
+			}
+		}
+	}
+}
+
+// Search ranks the indexed cars against the free text query q and returns
+// at most limit hits. Cars matching more query terms rank first, then by a
+// tf-idf style score weighted by field and by how exactly each term matched.
+func (ix *Index) Search(q string, limit int) []Hit {
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+
+	scores := make(map[string]*Hit)
+	total := float64(len(ix.docs))
+	for _, token := range dedupe(Tokenize(q)) {
+		matched := make(map[string]bool)
+		for term, weight := range ix.expand(token) {
+			posting := ix.postings[term]
+			idf := math.Log(1 + total/float64(len(posting)))
+			for id, boost := range posting {
+				hit, ok := scores[id]
+				if !ok {
+					hit = &Hit{Id: id}
+					scores[id] = hit
+				}
+				hit.Score += weight * boost * idf
+				if !matched[id] {
+					matched[id] = true
+					hit.Matched++
+				}
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for _, hit := range scores {
+		hits = append(hits, *hit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Matched != hits[j].Matched {
+			return hits[i].Matched > hits[j].Matched
+		}
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Id < hits[j].Id
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// expand returns the indexed terms token matches along with the weight of
+// each match: the term itself, terms it is a prefix of, and terms within a
+// small edit distance, allowing one typo from 4 characters and two from 8.
+// Prefixes are found by walking the terms from token on, and typos are only
+// looked for among the terms whose length is within that distance.
+func (ix *Index) expand(token string) map[string]float64 {
+	matches := make(map[string]float64)
+	if _, ok := ix.postings[token]; ok {
+		matches[token] = exactWeight
+	}
+	n := utf8.RuneCountInString(token)
+	if n >= minPrefixLen {
+		ix.terms.AscendGreaterOrEqual(token, func(term string) bool {
+			if !strings.HasPrefix(term, token) {
+				return false
+			}
+			if _, ok := matches[term]; !ok {
+				matches[term] = prefixWeight
+			}
+			return true
+		})
+	}
+
+	maxDistance := 0
+	switch {
+	case n >= 8:
+		maxDistance = 2
+	case n >= 4:
+		maxDistance = 1
+	}
+	for length := n - maxDistance; maxDistance > 0 && length <= n+maxDistance; length++ {
+		for term := range ix.lengths[length] {
+			if _, ok := matches[term]; !ok && withinDistance(token, term, maxDistance) {
+				matches[term] = fuzzyWeight
+			}
+		}
+	}
+	return matches
+}
+
+// Tokenize lower cases s and splits it into letter and digit runs.
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func dedupe(tokens []string) []string {
+	seen := make(map[string]bool, len(tokens))
+	out := tokens[:0]
+	for _, token := range tokens {
+		if !seen[token] {
+			seen[token] = true
+			out = append(out, token)
+		}
+	}
+	return out
+}
+
+// withinDistance reports whether the optimal string alignment distance
+// between a and b, counting adjacent transpositions as one edit, is at most
+// max.
+func withinDistance(a, b string, max int) bool {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return false
+	}
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = minInt(curr[j], prev2[j-2]+1)
+			}
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return false
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)] <= max
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,263 @@
+package search
+
+import (
+	"context"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"Honda", []string{"honda"}},
+		{"  F-150 Raptor, 2019 ", []string{"f", "150", "raptor", "2019"}},
+		{"Mercedes-Benz C300", []string{"mercedes", "benz", "c300"}},
+		{"CITROËN ë-C4", []string{"citroën", "ë", "c4"}},
+		{"--//..", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.in); (len(got) != 0 || len(tt.want) != 0) && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWithinDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		max  int
+		want bool
+	}{
+		{"honda", "honda", 0, true},
+		{"honda", "hinda", 1, true},
+		{"honda", "hnda", 1, true},
+		{"honda", "hondas", 1, true},
+		{"honda", "hodna", 1, true},
+		{"honda", "ohdna", 1, false},
+		{"honda", "ohdna", 2, true},
+		{"toyota", "tyoota", 1, true},
+		{"corolla", "carolla", 1, true},
+		{"corolla", "krolla", 1, false},
+		{"volkswagen", "volkswagon", 1, true},
+		{"volkswagen", "vlokswagon", 2, true},
+		{"volkswagen", "vlokswgaon", 2, false},
+		{"mustang", "mustangs1", 1, false},
+		{"citroën", "citroen", 1, true},
+	}
+	for _, tt := range tests {
+		if got := withinDistance(tt.a, tt.b, tt.max); got != tt.want {
+			t.Errorf("withinDistance(%q, %q, %d) = %v, want %v", tt.a, tt.b, tt.max, got, tt.want)
+		}
+	}
+}
+
+func car(id, make, model, color string, year int) *models.Car {
+	return &models.Car{Id: id, Make: make, Model: model, Color: color, Category: "Sedan", Year: year, Version: 1}
+}
+
+func newTestIndex(cars ...*models.Car) *Index {
+	ix := NewIndex()
+	for _, c := range cars {
+		ix.Put(c)
+	}
+	return ix
+}
+
+func ids(hits []Hit) []string {
+	out := make([]string, len(hits))
+	for i, hit := range hits {
+		out[i] = hit.Id
+	}
+	return out
+}
+
+func TestSearchMatches(t *testing.T) {
+	ix := newTestIndex(
+		car("civic", "Honda", "Civic", "Red", 2019),
+		car("accord", "Honda", "Accord", "Blue", 2015),
+		car("corolla", "Toyota", "Corolla", "Red", 2019),
+		car("golf", "Volkswagen", "Golf", "Black", 2012),
+		car("mustang", "Ford", "Mustang", "Yellow", 1967),
+	)
+	tests := []struct {
+		name string
+		q    string
+		want []string
+	}{
+		{"exact", "civic", []string{"civic"}},
+		{"case and punctuation", "  HONDA, civic!", []string{"civic", "accord"}},
+		{"prefix", "cor", []string{"corolla"}},
+		{"prefix of rarer years first", "201", []string{"accord", "golf", "civic", "corolla"}},
+		{"one letter is not a prefix", "c", nil},
+		{"typo", "hodna", []string{"accord", "civic"}},
+		{"transposition", "toytoa", []string{"corolla"}},
+		{"two typos in a long term", "volksvagon", []string{"golf"}},
+		{"two typos in a short term", "fxrf", nil},
+		{"short terms need exact matches", "frd", nil},
+		{"no match", "tesla", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(ix.Search(tt.q, 0))
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search(%q) = %v, want %v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	ix := newTestIndex(
+		car("red-civic", "Honda", "Civic", "Red", 2019),
+		car("blue-civic", "Honda", "Civic", "Blue", 2019),
+		car("red-fit", "Honda", "Fit", "Red", 2010),
+		car("red-corolla", "Toyota", "Corolla", "Red", 2019),
+		car("civic-like", "Hondo", "Civic", "Green", 2000),
+	)
+	hits := ix.Search("red honda civic", 0)
+
+	// Three terms matched come first, then two, then one, and the score
+	// decides among those matching as many: a rare make, even misspelt,
+	// and a model count for more than a color.
+	want := []string{"red-civic", "blue-civic", "civic-like", "red-fit", "red-corolla"}
+	if got := ids(hits); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ranked %v, want %v", got, want)
+	}
+	wantMatched := []int{3, 2, 2, 2, 1}
+	for i, hit := range hits {
+		if hit.Matched != wantMatched[i] {
+			t.Errorf("%v matched %d terms, want %d", hit.Id, hit.Matched, wantMatched[i])
+		}
+		if i > 0 && hit.Matched == hits[i-1].Matched && hit.Score > hits[i-1].Score {
+			t.Errorf("%v scores %v, more than %v ranked above it", hit.Id, hit.Score, hits[i-1].Id)
+		}
+	}
+
+	if got := ids(ix.Search("red honda civic", 2)); !reflect.DeepEqual(got, want[:2]) {
+		t.Errorf("limited to %v, want %v", got, want[:2])
+	}
+}
+
+func TestPutAndRemove(t *testing.T) {
+	civic := car("a", "Honda", "Civic", "Red", 2019)
+	ix := newTestIndex(civic)
+
+	// A newer version replaces the terms of the old one, and an older one
+	// arriving late is ignored.
+	fit := car("a", "Honda", "Fit", "Red", 2019)
+	fit.Version = 2
+	ix.Put(fit)
+	ix.Put(civic)
+	if got := ids(ix.Search("civic", 0)); len(got) != 0 {
+		t.Errorf("old version still found: %v", got)
+	}
+	if got := ids(ix.Search("fit", 0)); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("new version not found: %v", got)
+	}
+
+	// Archiving removes the car, and a late event for the version before
+	// the archive does not bring it back, but a restore does.
+	archived := *fit
+	archived.Version = 3
+	ix.remove("a", archived.Version)
+	ix.Put(fit)
+	if got := ids(ix.Search("fit", 0)); len(got) != 0 {
+		t.Errorf("archived car came back: %v", got)
+	}
+	restored := *fit
+	restored.Version = 4
+	ix.Put(&restored)
+	if got := ids(ix.Search("fit", 0)); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("restored car not found: %v", got)
+	}
+
+	ix.Remove("a")
+	ix.Put(&restored)
+	if got := ids(ix.Search("fit honda", 0)); len(got) != 0 {
+		t.Errorf("deleted car came back: %v", got)
+	}
+	if len(ix.postings) != 0 || ix.terms.Len() != 0 || len(ix.lengths) != 0 {
+		t.Errorf("terms of a deleted car left behind: %v", ix.postings)
+	}
+}
+
+// racingRepository runs write once it has listed the cars and before it
+// returns them, as a write landing between the listing and its use by Build
+// would.
+type racingRepository struct {
+	repository.Repository
+	write func()
+}
+
+func (r racingRepository) List(ctx context.Context, opts repository.ReadOptions) ([]*models.Car, error) {
+	cars, err := r.Repository.List(ctx, opts)
+	r.write()
+	return cars, err
+}
+
+func TestBuildRacingWrites(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repository.NewRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []*models.Car
+	for _, c := range []*models.Car{
+		car("", "Honda", "Civic", "Red", 2019),
+		car("", "Toyota", "Corolla", "Red", 2019),
+		car("", "Ford", "Mustang", "Red", 1967),
+		car("", "Kia", "Rio", "Red", 2018),
+	} {
+		if c, err = repo.Save(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, c)
+	}
+	civic, corolla, mustang := saved[0], saved[1], saved[2]
+
+	ix, err := Build(ctx, racingRepository{Repository: repo, write: func() {
+		update := *civic
+		update.Model = "Accord"
+		if _, err := repo.Update(ctx, &update); err != nil {
+			t.Error(err)
+		}
+		if _, err := repo.Archive(ctx, corolla.Id); err != nil {
+			t.Error(err)
+		}
+		if err := repo.Delete(ctx, mustang.Id); err != nil {
+			t.Error(err)
+		}
+		if _, err := repo.Save(ctx, car("", "Mazda", "Miata", "Red", 1990)); err != nil {
+			t.Error(err)
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		q    string
+		want int
+	}{
+		{"civic", 0},
+		{"accord", 1},
+		{"corolla", 0},
+		{"mustang", 0},
+		{"miata", 1},
+		{"rio", 1},
+		{"red", 3},
+	}
+	for _, tt := range tests {
+		if got := ix.Search(tt.q, 0); len(got) != tt.want {
+			t.Errorf("Search(%q) = %v, want %d hits", tt.q, got, tt.want)
+		}
+	}
+}
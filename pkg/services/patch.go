@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"github.com/hecomp/cars/pkg/vin"
+)
+
+// Media types of the supported patch formats.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// maxPatchAttempts bounds how often Patch re-reads and re-applies a patch
+// after losing a race with a concurrent write.
+const maxPatchAttempts = 5
+
+var (
+	// ErrInvalidPatch is returned, wrapped, when a patch document is
+	// malformed.
+	ErrInvalidPatch = repository.NewError(repository.KindInvalid, "invalid_patch", "invalid patch")
+	// ErrPatchConflict is returned, wrapped, when a well-formed patch cannot
+	// be applied to the current car, such as when a test operation fails or
+	// a path does not exist.
+	ErrPatchConflict = repository.NewError(repository.KindConflict, "patch_conflict", "patch does not apply")
+)
+
+// Patch transforms the JSON document of a car.
+type Patch interface {
+	Apply(doc []byte) ([]byte, error)
+}
+
+type mergePatch []byte
+
+// NewMergePatch parses a JSON Merge Patch (RFC 7396) document. Only object
+// patches are accepted, as any other value would replace the whole car.
+func NewMergePatch(body []byte) (Patch, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("%w: merge patch must be a JSON object: %v", ErrInvalidPatch, err)
+	}
+	return mergePatch(body), nil
+}
+
+func (p mergePatch) Apply(doc []byte) ([]byte, error) {
+	patched, err := jsonpatch.MergePatch(doc, p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return patched, nil
+}
+
+type jsonPatch struct {
+	ops jsonpatch.Patch
+}
+
+// NewJSONPatch parses a JSON Patch (RFC 6902) document.
+func NewJSONPatch(body []byte) (Patch, error) {
+	ops, err := jsonpatch.DecodePatch(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return jsonPatch{ops: ops}, nil
+}
+
+func (p jsonPatch) Apply(doc []byte) ([]byte, error) {
+	patched, err := p.ops.Apply(doc)
+	switch {
+	case err == nil:
+		return patched, nil
+	case errors.Is(err, jsonpatch.ErrTestFailed), errors.Is(err, jsonpatch.ErrMissing),
+		errors.Is(err, jsonpatch.ErrInvalidIndex):
+		return nil, fmt.Errorf("%w: %v", ErrPatchConflict, err)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+}
+
+// Patch applies patch to the live car with the given id and stores the
+// result once it passes validation. The id, version, listing time and
+// archive state of the car cannot be patched. When version is non-zero the
+// car must still be at that version. Otherwise a write racing with the patch
+// makes it start over from the newer car, so the patch is always applied to
+// what it replaces.
+func (s carsService) Patch(ctx context.Context, id string, version int64, patch Patch) (*models.Car, error) {
+	for attempt := 1; ; attempt++ {
+		current, err := s.repo.Find(ctx, id, repository.ReadOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if version != 0 && current.Version != version {
+			return nil, fmt.Errorf("%w: car %v is at version %d", repository.ErrVersionMismatch, id, current.Version)
+		}
+
+		car, err := applyPatch(current, patch)
+		if err != nil {
+			return nil, err
+		}
+		if err = Validate(car, CarRules...); err != nil {
+			return nil, err
+		}
+		car, err = s.repo.Update(ctx, car)
+		if errors.Is(err, repository.ErrVersionMismatch) && version == 0 && attempt < maxPatchAttempts {
+			continue
+		}
+		return car, err
+	}
+}
+
+func applyPatch(current *models.Car, patch Patch) (*models.Car, error) {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	doc, err = patch.Apply(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var car models.Car
+	if err = json.Unmarshal(doc, &car); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, &ValidationError{Fields: []FieldError{{
+				Field:   typeErr.Field,
+				Message: "must be of type " + typeErr.Type.String(),
+			}}}
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	car.Id = current.Id
+	car.Version = current.Version
+	car.CreatedAt = current.CreatedAt
+	car.DeletedAt = nil
+	car.VIN = vin.Normalize(car.VIN)
+	return &car, nil
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"github.com/hecomp/cars/pkg/search"
+	"reflect"
+	"testing"
+)
+
+func newTestService(t *testing.T) (CarsService, repository.Repository) {
+	t.Helper()
+	repo, err := repository.NewRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := search.Build(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewCarsService(repo, index), repo
+}
+
+func sampleCar() *models.Car {
+	return &models.Car{
+		Make:     "Honda",
+		Model:    "Civic",
+		Package:  "Sport",
+		Color:    "Red",
+		Category: "Sedan",
+		Year:     2003,
+		Mileage:  1000,
+		Price:    20000,
+		VIN:      "1HGCM82633A004352",
+	}
+}
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		newPatch  func([]byte) (Patch, error)
+		body      string
+		want      func(car *models.Car)
+		wantErr   error
+		wantField string
+	}{
+		{
+			name:     "merge sets fields",
+			newPatch: NewMergePatch,
+			body:     `{"color":"Blue","price":18000}`,
+			want:     func(car *models.Car) { car.Color, car.Price = "Blue", 18000 },
+		},
+		{
+			name:     "merge null clears an optional field",
+			newPatch: NewMergePatch,
+			body:     `{"package":null,"vin":null}`,
+			want:     func(car *models.Car) { car.Package, car.VIN = "", "" },
+		},
+		{
+			name:     "merge null zeroes a number",
+			newPatch: NewMergePatch,
+			body:     `{"mileage":null}`,
+			want:     func(car *models.Car) { car.Mileage = 0 },
+		},
+		{
+			name:      "merge null clears a required field",
+			newPatch:  NewMergePatch,
+			body:      `{"make":null}`,
+			wantErr:   ErrValidation,
+			wantField: "make",
+		},
+		{
+			name:     "merge leaves id and version alone",
+			newPatch: NewMergePatch,
+			body:     `{"id":"other","version":42,"deletedAt":"2020-01-01T00:00:00Z"}`,
+			want:     func(car *models.Car) {},
+		},
+		{
+			name:     "merge upper cases the vin",
+			newPatch: NewMergePatch,
+			body:     `{"vin":" 1hgcm82633a004352 "}`,
+			want:     func(car *models.Car) {},
+		},
+		{
+			name:      "merge of the wrong type",
+			newPatch:  NewMergePatch,
+			body:      `{"year":"new"}`,
+			wantErr:   ErrValidation,
+			wantField: "year",
+		},
+		{
+			name:     "merge of a non-object",
+			newPatch: NewMergePatch,
+			body:     `["color"]`,
+			wantErr:  ErrInvalidPatch,
+		},
+		{
+			name:     "passing test guards the change",
+			newPatch: NewJSONPatch,
+			body:     `[{"op":"test","path":"/price","value":20000},{"op":"replace","path":"/price","value":19000}]`,
+			want:     func(car *models.Car) { car.Price = 19000 },
+		},
+		{
+			name:     "failing test leaves the car alone",
+			newPatch: NewJSONPatch,
+			body:     `[{"op":"replace","path":"/color","value":"Blue"},{"op":"test","path":"/price","value":1}]`,
+			wantErr:  ErrPatchConflict,
+		},
+		{
+			name:     "test of a missing path",
+			newPatch: NewJSONPatch,
+			body:     `[{"op":"test","path":"/owner","value":"me"}]`,
+			wantErr:  ErrPatchConflict,
+		},
+		{
+			name:     "test of null against a missing path",
+			newPatch: NewJSONPatch,
+			body:     `[{"op":"test","path":"/vin","value":null},{"op":"replace","path":"/color","value":"Blue"}]`,
+			wantErr:  ErrPatchConflict,
+		},
+		{
+			name:     "remove clears a field",
+			newPatch: NewJSONPatch,
+			body:     `[{"op":"remove","path":"/package"}]`,
+			want:     func(car *models.Car) { car.Package = "" },
+		},
+		{
+			name:     "replace of a missing path",
+			newPatch: NewJSONPatch,
+			body:     `[{"op":"replace","path":"/owner","value":"me"}]`,
+			wantErr:  ErrPatchConflict,
+		},
+		{
+			name:     "unknown operation",
+			newPatch: NewJSONPatch,
+			body:     `[{"op":"frobnicate","path":"/color"}]`,
+			wantErr:  ErrInvalidPatch,
+		},
+		{
+			name:     "not a patch",
+			newPatch: NewJSONPatch,
+			body:     `{"op":"remove","path":"/color"}`,
+			wantErr:  ErrInvalidPatch,
+		},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, repo := newTestService(t)
+			stored, err := repo.Save(ctx, sampleCar())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			patch, err := tt.newPatch([]byte(tt.body))
+			var got *models.Car
+			if err == nil {
+				got, err = svc.Patch(ctx, stored.Id, 0, patch)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Patch error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantField != "" {
+				var verr *ValidationError
+				if !errors.As(err, &verr) || verr.Fields[0].Field != tt.wantField {
+					t.Errorf("invalid fields = %v, want %v first", err, tt.wantField)
+				}
+			}
+
+			current, err := repo.Find(ctx, stored.Id, repository.ReadOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := *stored
+			if tt.wantErr == nil {
+				tt.want(&want)
+				want.Version++
+				if !reflect.DeepEqual(got, current) {
+					t.Errorf("returned %+v, stored %+v", *got, *current)
+				}
+			}
+			if current.Id != want.Id || current.Make != want.Make || current.Package != want.Package ||
+				current.Color != want.Color || current.Price != want.Price || current.Mileage != want.Mileage ||
+				current.VIN != want.VIN || current.Version != want.Version || current.DeletedAt != nil {
+				t.Errorf("stored %+v, want %+v", *current, want)
+			}
+		})
+	}
+}
+
+func TestPatchVersion(t *testing.T) {
+	ctx := context.Background()
+	svc, repo := newTestService(t)
+	stored, err := repo.Save(ctx, sampleCar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch, err := NewMergePatch([]byte(`{"color":"Blue"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = svc.Patch(ctx, stored.Id, stored.Version+1, patch); !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("stale version: %v, want %v", err, repository.ErrVersionMismatch)
+	}
+	if _, err = svc.Patch(ctx, stored.Id, stored.Version, patch); err != nil {
+		t.Errorf("current version: %v", err)
+	}
+	if _, err = svc.Patch(ctx, "missing", 0, patch); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("missing car: %v, want %v", err, repository.ErrNotFound)
+	}
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"context"
+	"golang.org/x/exp/slog"
+	"time"
+)
+
+// RunPurger periodically purges cars archived for longer than retention. It
+// blocks until ctx is cancelled.
+func RunPurger(ctx context.Context, svc CarsService, retention, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := svc.Purge(ctx, retention)
+			if err != nil {
+				logger.ErrorCtx(ctx, "Error purging archived cars", "error", err)
+				continue
+			}
+			if n > 0 {
+				logger.InfoCtx(ctx, "Purged archived cars", "count", n)
+			}
+		}
+	}
+}
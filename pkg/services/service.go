@@ -1,51 +1,180 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/hecomp/cars/internal/models"
 	"github.com/hecomp/cars/pkg/repository"
+	"github.com/hecomp/cars/pkg/search"
+	"github.com/hecomp/cars/pkg/vin"
+	"time"
 )
 
+// CarsService is the business logic over the stored cars. Its methods stop
+// with the error of their context once the context is done.
 type CarsService interface {
-	GetCar(id string) (*models.Car, error)
-	GetCars() []*models.Car
-	Create(user *models.Car) (*models.Car, error)
-	Update(user *models.Car) (*models.Car, error)
+	GetCar(ctx context.Context, id string, opts repository.ReadOptions) (*models.Car, error)
+	GetCarByVIN(ctx context.Context, vin string, opts repository.ReadOptions) (*models.Car, error)
+	GetCars(ctx context.Context, opts repository.ReadOptions) ([]*models.Car, error)
+	QueryCars(ctx context.Context, q repository.Query) (*repository.Page, error)
+	EachCar(ctx context.Context, q repository.Query, fn func(*models.Car) error) error
+	Search(ctx context.Context, q string, limit int) ([]*models.Car, error)
+	Create(ctx context.Context, user *models.Car) (*models.Car, error)
+	Update(ctx context.Context, user *models.Car) (*models.Car, error)
+	Patch(ctx context.Context, id string, version int64, patch Patch) (*models.Car, error)
+	Batch(ctx context.Context, ops []repository.Op, mode repository.BatchMode) ([]repository.Result, error)
+	Import(ctx context.Context, rows RowReader, dryRun bool) (*ImportReport, error)
+	Archive(ctx context.Context, id string) (*models.Car, error)
+	Restore(ctx context.Context, id string) (*models.Car, error)
+	Delete(ctx context.Context, id string) error
+	Purge(ctx context.Context, retention time.Duration) (int, error)
 }
 
 type carsService struct {
-	repo repository.Repository
+	repo  repository.Repository
+	index *search.Index
 }
 
-func NewCarsService(repo repository.Repository) CarsService {
+func NewCarsService(repo repository.Repository, index *search.Index) CarsService {
 	return &carsService{
-		repo: repo,
+		repo:  repo,
+		index: index,
 	}
 }
 
-func (s carsService) GetCar(id string) (*models.Car, error) {
-	car, err := s.repo.Find(id)
+func (s carsService) GetCar(ctx context.Context, id string, opts repository.ReadOptions) (*models.Car, error) {
+	car, err := s.repo.Find(ctx, id, opts)
 	if err != nil {
 		return nil, err
 	}
 	return car, nil
 }
 
-func (s carsService) GetCars() []*models.Car {
-	return s.repo.List()
+func (s carsService) GetCarByVIN(ctx context.Context, v string, opts repository.ReadOptions) (*models.Car, error) {
+	return s.repo.FindByVIN(ctx, vin.Normalize(v), opts)
 }
 
-func (s carsService) Create(car *models.Car) (*models.Car, error) {
-	car, err := s.repo.Save(car)
+func (s carsService) GetCars(ctx context.Context, opts repository.ReadOptions) ([]*models.Car, error) {
+	return s.repo.List(ctx, opts)
+}
+
+func (s carsService) QueryCars(ctx context.Context, q repository.Query) (*repository.Page, error) {
+	return s.repo.Query(ctx, q)
+}
+
+// EachCar calls fn with every car matching q, in its order, ignoring its
+// limit. Cars are read from the repository as fn takes them, so listings of
+// any size never sit in memory at once. It stops at the first error fn
+// returns, or when ctx is cancelled.
+func (s carsService) EachCar(ctx context.Context, q repository.Query, fn func(*models.Car) error) error {
+	it, err := s.repo.Iterate(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	for it.Next() {
+		if err = fn(it.Car()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// Search returns the live cars best matching the free text query q, most
+// relevant first.
+func (s carsService) Search(ctx context.Context, q string, limit int) ([]*models.Car, error) {
+	hits := s.index.Search(q, limit)
+	cars := make([]*models.Car, 0, len(hits))
+	for _, hit := range hits {
+		car, err := s.repo.Find(ctx, hit.Id, repository.ReadOptions{})
+		if errors.Is(err, repository.ErrNotFound) {
+			// Removed since the index was searched.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cars = append(cars, car)
+	}
+	return cars, nil
+}
+
+func (s carsService) Create(ctx context.Context, car *models.Car) (*models.Car, error) {
+	car.VIN = vin.Normalize(car.VIN)
+	if err := Validate(car, CarRules...); err != nil {
+		return nil, err
+	}
+	car, err := s.repo.Save(ctx, car)
 	if err != nil {
 		return nil, err
 	}
 	return car, nil
 }
 
-func (s carsService) Update(car *models.Car) (*models.Car, error) {
-	car, err := s.repo.Update(car)
+func (s carsService) Update(ctx context.Context, car *models.Car) (*models.Car, error) {
+	car.VIN = vin.Normalize(car.VIN)
+	if err := Validate(car, CarRules...); err != nil {
+		return nil, err
+	}
+	car, err := s.repo.Update(ctx, car)
 	if err != nil {
 		return nil, err
 	}
 	return car, nil
 }
+
+// Batch validates the cars of ops and applies them in order. Invalid
+// operations fail an AllOrNothing batch before anything is written and are
+// skipped, with their errors reported, in a BestEffort or DryRun one.
+func (s carsService) Batch(ctx context.Context, ops []repository.Op, mode repository.BatchMode) ([]repository.Result, error) {
+	results := make([]repository.Result, len(ops))
+	valid := make([]repository.Op, 0, len(ops))
+	positions := make([]int, 0, len(ops))
+	for i, op := range ops {
+		if op.Car != nil && (op.Kind == repository.OpCreate || op.Kind == repository.OpUpdate) {
+			op.Car.VIN = vin.Normalize(op.Car.VIN)
+			if err := Validate(op.Car, CarRules...); err != nil {
+				results[i].Err = err
+				if mode == repository.AllOrNothing {
+					for j := range results {
+						if j != i {
+							results[j].Err = repository.ErrNotApplied
+						}
+					}
+					return results, fmt.Errorf("operation %d: %w", i, err)
+				}
+				continue
+			}
+		}
+		valid = append(valid, op)
+		positions = append(positions, i)
+	}
+
+	// Only an AllOrNothing batch fails as a whole over one operation, and it
+	// never skips any, so the positions in err match those in ops.
+	applied, err := s.repo.Batch(ctx, valid, mode)
+	for k, result := range applied {
+		results[positions[k]] = result
+	}
+	return results, err
+}
+
+func (s carsService) Archive(ctx context.Context, id string) (*models.Car, error) {
+	return s.repo.Archive(ctx, id)
+}
+
+func (s carsService) Restore(ctx context.Context, id string) (*models.Car, error) {
+	return s.repo.Restore(ctx, id)
+}
+
+func (s carsService) Delete(ctx context.Context, id string) error {
+	return s.repo.Delete(ctx, id)
+}
+
+// Purge permanently removes cars that have been archived for longer than
+// retention.
+func (s carsService) Purge(ctx context.Context, retention time.Duration) (int, error) {
+	return s.repo.Purge(ctx, time.Now().Add(-retention))
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/internal/telemetry/tracing"
+	"github.com/hecomp/cars/pkg/repository"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+var tracer = otel.Tracer("github.com/hecomp/cars/pkg/services")
+
+// tracedService wraps every call to a service in a span, between the span of
+// the request and those of the repository calls it makes.
+type tracedService struct {
+	next CarsService
+}
+
+// Traced returns svc with every call traced as a child of the span in its
+// context.
+func Traced(svc CarsService) CarsService {
+	return tracedService{next: svc}
+}
+
+func carID(id string) trace.SpanStartOption {
+	return trace.WithAttributes(attribute.String("car.id", id))
+}
+
+func (s tracedService) GetCar(ctx context.Context, id string, opts repository.ReadOptions) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.GetCar", carID(id))
+	car, err := s.next.GetCar(ctx, id, opts)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (s tracedService) GetCarByVIN(ctx context.Context, vin string, opts repository.ReadOptions) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.GetCarByVIN")
+	car, err := s.next.GetCarByVIN(ctx, vin, opts)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (s tracedService) GetCars(ctx context.Context, opts repository.ReadOptions) ([]*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.GetCars")
+	cars, err := s.next.GetCars(ctx, opts)
+	tracing.End(span, err)
+	return cars, err
+}
+
+func (s tracedService) QueryCars(ctx context.Context, q repository.Query) (*repository.Page, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.QueryCars")
+	page, err := s.next.QueryCars(ctx, q)
+	tracing.End(span, err)
+	return page, err
+}
+
+func (s tracedService) EachCar(ctx context.Context, q repository.Query, fn func(*models.Car) error) error {
+	ctx, span := tracer.Start(ctx, "CarsService.EachCar")
+	err := s.next.EachCar(ctx, q, fn)
+	tracing.End(span, err)
+	return err
+}
+
+func (s tracedService) Search(ctx context.Context, q string, limit int) ([]*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.Search",
+		trace.WithAttributes(attribute.Int("search.limit", limit)))
+	cars, err := s.next.Search(ctx, q, limit)
+	span.SetAttributes(attribute.Int("cars.count", len(cars)))
+	tracing.End(span, err)
+	return cars, err
+}
+
+func (s tracedService) Create(ctx context.Context, user *models.Car) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.Create")
+	car, err := s.next.Create(ctx, user)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (s tracedService) Update(ctx context.Context, user *models.Car) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.Update", carID(user.Id))
+	car, err := s.next.Update(ctx, user)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (s tracedService) Patch(ctx context.Context, id string, version int64, patch Patch) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.Patch", carID(id))
+	car, err := s.next.Patch(ctx, id, version, patch)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (s tracedService) Batch(ctx context.Context, ops []repository.Op, mode repository.BatchMode) ([]repository.Result, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.Batch",
+		trace.WithAttributes(attribute.Int("batch.size", len(ops))))
+	results, err := s.next.Batch(ctx, ops, mode)
+	tracing.End(span, err)
+	return results, err
+}
+
+func (s tracedService) Import(ctx context.Context, rows RowReader, dryRun bool) (*ImportReport, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.Import",
+		trace.WithAttributes(attribute.Bool("import.dry_run", dryRun)))
+	report, err := s.next.Import(ctx, rows, dryRun)
+	if report != nil {
+		span.SetAttributes(attribute.Int("import.rows", report.Rows),
+			attribute.Int("import.failed", len(report.Errors)))
+	}
+	tracing.End(span, err)
+	return report, err
+}
+
+func (s tracedService) Archive(ctx context.Context, id string) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.Archive", carID(id))
+	car, err := s.next.Archive(ctx, id)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (s tracedService) Restore(ctx context.Context, id string) (*models.Car, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.Restore", carID(id))
+	car, err := s.next.Restore(ctx, id)
+	tracing.End(span, err)
+	return car, err
+}
+
+func (s tracedService) Delete(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "CarsService.Delete", carID(id))
+	err := s.next.Delete(ctx, id)
+	tracing.End(span, err)
+	return err
+}
+
+func (s tracedService) Purge(ctx context.Context, retention time.Duration) (int, error) {
+	ctx, span := tracer.Start(ctx, "CarsService.Purge")
+	n, err := s.next.Purge(ctx, retention)
+	tracing.End(span, err)
+	return n, err
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"io"
+)
+
+// importBatchSize is how many rows Import writes in one batch.
+const importBatchSize = 500
+
+// RowReader yields the cars of an import one row at a time.
+type RowReader interface {
+	// Next returns the next car and the line it starts on, or io.EOF after
+	// the last one. A *RowError means the row is unusable but reading can go
+	// on; any other error ends the import.
+	Next() (*models.Car, int, error)
+}
+
+// RowError is a row of an import that could not be read or written.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// ImportReport sums up an import. In a dry run the counts are what the
+// import would have done.
+type ImportReport struct {
+	Rows    int
+	Created int
+	Updated int
+	Errors  []*RowError
+}
+
+// Import creates the rows of rows without an id and updates those with one,
+// pinned to their version when they have one. Rows are read as they are
+// written, a batch at a time, and every row that fails is reported with its
+// line while the others are still written. A dry run checks every row
+// against the current cars, and against the rows before it in its batch,
+// without writing anything.
+func (s carsService) Import(ctx context.Context, rows RowReader, dryRun bool) (*ImportReport, error) {
+	mode := repository.BestEffort
+	if dryRun {
+		mode = repository.DryRun
+	}
+
+	report := &ImportReport{}
+	ops := make([]repository.Op, 0, importBatchSize)
+	lines := make([]int, 0, importBatchSize)
+	flush := func() error {
+		if len(ops) == 0 {
+			return nil
+		}
+		results, err := s.Batch(ctx, ops, mode)
+		if err != nil {
+			return err
+		}
+		for i, result := range results {
+			switch {
+			case result.Err != nil:
+				report.Errors = append(report.Errors, &RowError{Line: lines[i], Err: result.Err})
+			case ops[i].Kind == repository.OpCreate:
+				report.Created++
+			default:
+				report.Updated++
+			}
+		}
+		ops, lines = ops[:0], lines[:0]
+		return nil
+	}
+
+	for {
+		car, line, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			report.Rows++
+			report.Errors = append(report.Errors, rowErr)
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		report.Rows++
+		op := repository.Op{Kind: repository.OpCreate, Car: car}
+		if car.Id != "" {
+			op.Kind = repository.OpUpdate
+		}
+		ops = append(ops, op)
+		lines = append(lines, line)
+		if len(ops) == importBatchSize {
+			if err = flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, flush()
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// sliceReader yields its rows, numbered from line 2 as under a CSV header.
+type sliceReader struct {
+	rows []interface{}
+	next int
+}
+
+func (r *sliceReader) Next() (*models.Car, int, error) {
+	if r.next == len(r.rows) {
+		return nil, 0, io.EOF
+	}
+	row, line := r.rows[r.next], r.next+2
+	r.next++
+	if err, ok := row.(error); ok {
+		return nil, line, &RowError{Line: line, Err: err}
+	}
+	return row.(*models.Car).Clone(), line, nil
+}
+
+func TestImport(t *testing.T) {
+	errUnreadable := errors.New("unreadable row")
+	tests := []struct {
+		name   string
+		dryRun bool
+	}{
+		{"import", false},
+		{"dry run", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			svc, repo := newTestService(t)
+			existing := sampleCar()
+			existing.VIN = ""
+			existing, err := repo.Save(ctx, existing)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, err := repo.List(ctx, repository.ReadOptions{IncludeArchived: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			update := existing.Clone()
+			update.Price = 1
+			stale := existing.Clone()
+			stale.Version = 7
+			invalid := sampleCar()
+			invalid.Make = ""
+			rows := &sliceReader{rows: []interface{}{
+				update,
+				sampleCar(),
+				errUnreadable,
+				sampleCar(),
+				invalid,
+				stale,
+			}}
+
+			report, err := svc.Import(ctx, rows, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Rows != 6 || report.Created != 1 || report.Updated != 1 {
+				t.Errorf("report = %+v, want 6 rows, 1 created and 1 updated", report)
+			}
+			want := []struct {
+				line int
+				err  error
+			}{
+				{4, errUnreadable},
+				{5, repository.ErrDuplicateVIN},
+				{6, ErrValidation},
+				{7, repository.ErrVersionMismatch},
+			}
+			if len(report.Errors) != len(want) {
+				t.Fatalf("errors = %v, want %d", report.Errors, len(want))
+			}
+			for i, w := range want {
+				if got := report.Errors[i]; got.Line != w.line || !errors.Is(got, w.err) {
+					t.Errorf("error %d = %v, want line %d: %v", i, got, w.line, w.err)
+				}
+			}
+
+			after, err := repo.List(ctx, repository.ReadOptions{IncludeArchived: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.dryRun {
+				if !reflect.DeepEqual(after, before) {
+					t.Errorf("dry run wrote cars:\n got %+v\nwant %+v", after, before)
+				}
+				return
+			}
+			if len(after) != 2 {
+				t.Errorf("stored %d cars, want 2", len(after))
+			}
+			if got, err := repo.Find(ctx, existing.Id, repository.ReadOptions{}); err != nil || got.Price != 1 {
+				t.Errorf("updated car = %+v, %v", got, err)
+			}
+		})
+	}
+}
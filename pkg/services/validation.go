@@ -0,0 +1,191 @@
+package services
+
+import (
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"github.com/hecomp/cars/pkg/vin"
+	"strings"
+	"time"
+)
+
+// FirstModelYear is the year of the first production automobile.
+const FirstModelYear = 1886
+
+// Categories lists the accepted values of models.Car.Category.
+var Categories = []string{
+	"Convertible", "Coupe", "Crossover", "Hatchback", "Minivan",
+	"Pickup", "Sedan", "SUV", "Truck", "Van", "Wagon",
+}
+
+// ErrValidation is the domain error every *ValidationError wraps.
+var ErrValidation = repository.NewError(repository.KindValidation, "validation_failed", "invalid car")
+
+// FieldError describes why a single field of a car is invalid. Field is the
+// JSON name of the field.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError lists every invalid field of a car.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return ErrValidation.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// Rule checks one aspect of a car and returns nil when it holds.
+type Rule func(car *models.Car) *FieldError
+
+// Required rejects an empty or blank string field.
+func Required(field string, value func(*models.Car) string) Rule {
+	return func(car *models.Car) *FieldError {
+		if strings.TrimSpace(value(car)) == "" {
+			return &FieldError{Field: field, Message: "is required"}
+		}
+		return nil
+	}
+}
+
+// Between rejects an integer field outside [min(), max()]. The bounds are
+// functions so they can move with the clock.
+func Between(field string, value func(*models.Car) int, min, max func() int) Rule {
+	return func(car *models.Car) *FieldError {
+		v, lo, hi := value(car), min(), max()
+		if v < lo || v > hi {
+			return &FieldError{Field: field, Message: fmt.Sprintf("must be between %d and %d", lo, hi)}
+		}
+		return nil
+	}
+}
+
+// NonNegative rejects a negative integer field.
+func NonNegative(field string, value func(*models.Car) int) Rule {
+	return func(car *models.Car) *FieldError {
+		if value(car) < 0 {
+			return &FieldError{Field: field, Message: "must not be negative"}
+		}
+		return nil
+	}
+}
+
+// OneOf rejects a non-empty string field that is not one of allowed,
+// compared case-insensitively.
+func OneOf(field string, value func(*models.Car) string, allowed ...string) Rule {
+	return func(car *models.Car) *FieldError {
+		v := value(car)
+		if v == "" {
+			return nil
+		}
+		for _, a := range allowed {
+			if strings.EqualFold(v, a) {
+				return nil
+			}
+		}
+		return &FieldError{Field: field, Message: "must be one of " + strings.Join(allowed, ", ")}
+	}
+}
+
+// VIN rejects a malformed VIN or one whose check digit does not match. An
+// empty VIN is allowed.
+func VIN(field string, value func(*models.Car) string) Rule {
+	return func(car *models.Car) *FieldError {
+		v := value(car)
+		if v == "" {
+			return nil
+		}
+		if err := vin.Validate(v); err != nil {
+			return &FieldError{Field: field, Message: err.Error()}
+		}
+		return nil
+	}
+}
+
+// VINMatchesMake rejects a make that is not sold under the manufacturer the
+// VIN was issued to. It holds when the VIN is empty, invalid or from a
+// manufacturer missing from the embedded table.
+func VINMatchesMake(field string) Rule {
+	return func(car *models.Car) *FieldError {
+		info, ok := decodeVIN(car)
+		if !ok || info.MatchesMake(car.Make) {
+			return nil
+		}
+		return &FieldError{
+			Field:   field,
+			Message: fmt.Sprintf("does not match vin manufacturer %v (%v)", info.Manufacturer.Name, strings.Join(info.Manufacturer.Makes, ", ")),
+		}
+	}
+}
+
+// VINMatchesYear rejects a year that is not one the model year character of
+// the VIN stands for. It holds when the VIN is empty or invalid.
+func VINMatchesYear(field string) Rule {
+	return func(car *models.Car) *FieldError {
+		info, ok := decodeVIN(car)
+		if !ok || len(info.ModelYears) == 0 || info.MatchesYear(car.Year) {
+			return nil
+		}
+		years := make([]string, len(info.ModelYears))
+		for i, y := range info.ModelYears {
+			years[i] = fmt.Sprint(y)
+		}
+		return &FieldError{Field: field, Message: "does not match vin model year " + strings.Join(years, " or ")}
+	}
+}
+
+func decodeVIN(car *models.Car) (vin.Info, bool) {
+	if car.VIN == "" {
+		return vin.Info{}, false
+	}
+	info, err := vin.Decode(car.VIN, nextModelYear())
+	return info, err == nil
+}
+
+func constant(v int) func() int {
+	return func() int { return v }
+}
+
+// nextModelYear is the latest model year on sale, as manufacturers release
+// next year's models during the current one.
+func nextModelYear() int {
+	return time.Now().Year() + 1
+}
+
+// CarRules are the rules every created or updated car must satisfy.
+var CarRules = []Rule{
+	Required("make", func(c *models.Car) string { return c.Make }),
+	Required("model", func(c *models.Car) string { return c.Model }),
+	Between("year", func(c *models.Car) int { return c.Year }, constant(FirstModelYear), nextModelYear),
+	NonNegative("mileage", func(c *models.Car) int { return c.Mileage }),
+	NonNegative("price", func(c *models.Car) int { return c.Price }),
+	OneOf("Category", func(c *models.Car) string { return c.Category }, Categories...),
+	VIN("vin", func(c *models.Car) string { return c.VIN }),
+	VINMatchesMake("make"),
+	VINMatchesYear("year"),
+}
+
+// Validate checks car against rules and returns a *ValidationError listing
+// every failed rule, or nil.
+func Validate(car *models.Car, rules ...Rule) error {
+	var errs []FieldError
+	for _, rule := range rules {
+		if fe := rule(car); fe != nil {
+			errs = append(errs, *fe)
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"github.com/hecomp/cars/pkg/vin"
+	"reflect"
+	"testing"
+)
+
+func TestCarRules(t *testing.T) {
+	valid := func() *models.Car {
+		return &models.Car{
+			Make:     "Honda",
+			Model:    "Civic",
+			Package:  "EX",
+			Color:    "Red",
+			Category: "Sedan",
+			Year:     2003,
+			Price:    5000,
+			Mileage:  120000,
+			VIN:      "1HGCM82633A004352",
+		}
+	}
+	latest := nextModelYear()
+	tests := []struct {
+		name   string
+		change func(c *models.Car)
+		want   []FieldError
+	}{
+		{"valid", func(c *models.Car) {}, nil},
+		{"without a vin", func(c *models.Car) { c.VIN, c.Year = "", latest }, nil},
+		{"category in any case", func(c *models.Car) { c.Category = "sEdAn" }, nil},
+		{"without a category", func(c *models.Car) { c.Category = "" }, nil},
+		{"free price and mileage", func(c *models.Car) { c.Price, c.Mileage = 0, 0 }, nil},
+		{"first model year", func(c *models.Car) { c.VIN, c.Year = "", FirstModelYear }, nil},
+		{"make matching the vin in any case", func(c *models.Car) { c.Make = " honda " }, nil},
+		{"vin of an unknown manufacturer", func(c *models.Car) { c.VIN, c.Make = "ZZZZZZZZ9ZZZZZZZZ", "Lada" }, nil},
+
+		{"missing make", func(c *models.Car) { c.VIN, c.Make = "", "" }, []FieldError{
+			{"make", "is required"},
+		}},
+		{"blank model", func(c *models.Car) { c.Model = " \t" }, []FieldError{
+			{"model", "is required"},
+		}},
+		{"year too old", func(c *models.Car) { c.VIN, c.Year = "", FirstModelYear-1 }, []FieldError{
+			{"year", fmt.Sprintf("must be between %d and %d", FirstModelYear, latest)},
+		}},
+		{"year too new", func(c *models.Car) { c.VIN, c.Year = "", latest+1 }, []FieldError{
+			{"year", fmt.Sprintf("must be between %d and %d", FirstModelYear, latest)},
+		}},
+		{"negative mileage", func(c *models.Car) { c.Mileage = -1 }, []FieldError{
+			{"mileage", "must not be negative"},
+		}},
+		{"negative price", func(c *models.Car) { c.Price = -1 }, []FieldError{
+			{"price", "must not be negative"},
+		}},
+		{"unknown category", func(c *models.Car) { c.Category = "Spaceship" }, []FieldError{
+			{"Category", "must be one of Convertible, Coupe, Crossover, Hatchback, Minivan, Pickup, Sedan, SUV, Truck, Van, Wagon"},
+		}},
+		{"check digit", func(c *models.Car) { c.VIN = "1HGCM82631A004352" }, []FieldError{
+			{"vin", vin.Validate("1HGCM82631A004352").Error()},
+		}},
+		{"short vin", func(c *models.Car) { c.VIN = "1HGCM8263" }, []FieldError{
+			{"vin", vin.Validate("1HGCM8263").Error()},
+		}},
+		{"make not of the vin manufacturer", func(c *models.Car) { c.Make = "Ford" }, []FieldError{
+			{"make", "does not match vin manufacturer Honda (Honda)"},
+		}},
+		{"year not of the vin", func(c *models.Car) { c.Year = 2004 }, []FieldError{
+			{"year", "does not match vin model year 2003"},
+		}},
+		{"every rule in order", func(c *models.Car) {
+			*c = models.Car{Year: 1800, Mileage: -5, Price: -5, Category: "Boat", VIN: "1HGCM82633A004352"}
+		}, []FieldError{
+			{"make", "is required"},
+			{"model", "is required"},
+			{"year", fmt.Sprintf("must be between %d and %d", FirstModelYear, latest)},
+			{"mileage", "must not be negative"},
+			{"price", "must not be negative"},
+			{"Category", "must be one of Convertible, Coupe, Crossover, Hatchback, Minivan, Pickup, Sedan, SUV, Truck, Van, Wagon"},
+			{"make", "does not match vin manufacturer Honda (Honda)"},
+			{"year", "does not match vin model year 2003"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			car := valid()
+			tt.change(car)
+			err := Validate(car, CarRules...)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate = %v, want a *ValidationError", err)
+			}
+			if !reflect.DeepEqual(verr.Fields, tt.want) {
+				t.Errorf("fields = %+v\nwant %+v", verr.Fields, tt.want)
+			}
+			if !errors.Is(err, ErrValidation) || repository.KindOf(err) != repository.KindValidation {
+				t.Errorf("%v does not wrap %v", err, ErrValidation)
+			}
+		})
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	err := &ValidationError{Fields: []FieldError{
+		{"make", "is required"},
+		{"year", "must be between 1886 and 2027"},
+	}}
+	if got, want := err.Error(), "invalid car: make: is required; year: must be between 1886 and 2027"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
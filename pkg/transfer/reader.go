@@ -0,0 +1,158 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/services"
+	"io"
+	"sort"
+	"strings"
+)
+
+// NewReader returns a reader of the cars in r, one per CSV row or NDJSON
+// line, with columns or keys renamed by m. The header of a CSV file is read
+// straight away, so an unknown column is reported before any row.
+func NewReader(f Format, r io.Reader, m Mapping) (services.RowReader, error) {
+	if f == NDJSON {
+		return &ndjsonReader{r: bufio.NewReader(r), mapping: m}, nil
+	}
+
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return &csvReader{r: cr}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformed, err)
+	}
+	// Spreadsheets often save CSV files with a byte order mark.
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	columns := make([]field, len(header))
+	seen := make(map[string]string)
+	var unknown []string
+	for i, name := range header {
+		col, ok := m.resolve(name)
+		if !ok {
+			unknown = append(unknown, fmt.Sprintf("%q", name))
+			continue
+		}
+		if prev, dup := seen[col.name]; dup && col.name != "" {
+			return nil, fmt.Errorf("%w: %q and %q both map to %v", ErrInvalidMapping, prev, name, col.name)
+		}
+		seen[col.name] = name
+		columns[i] = col
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownColumn, strings.Join(unknown, ", "))
+	}
+	return &csvReader{r: cr, columns: columns}, nil
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns []field
+}
+
+func (cr *csvReader) Next() (*models.Car, int, error) {
+	if cr.columns == nil {
+		return nil, 0, io.EOF
+	}
+	record, err := cr.r.Read()
+	var perr *csv.ParseError
+	if errors.As(err, &perr) {
+		return nil, perr.StartLine, &services.RowError{Line: perr.StartLine, Err: fmt.Errorf("%w: %v", ErrMalformed, perr.Err)}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	line, _ := cr.r.FieldPos(0)
+
+	car := &models.Car{}
+	var errs []services.FieldError
+	for i, col := range cr.columns {
+		if col.set == nil {
+			continue
+		}
+		if err := col.set(car, strings.TrimSpace(record[i])); err != nil {
+			errs = append(errs, services.FieldError{Field: col.json, Message: err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, line, &services.RowError{Line: line, Err: &services.ValidationError{Fields: errs}}
+	}
+	return car, line, nil
+}
+
+type ndjsonReader struct {
+	r       *bufio.Reader
+	mapping Mapping
+	line    int
+}
+
+func (nr *ndjsonReader) Next() (*models.Car, int, error) {
+	for {
+		raw, err := nr.r.ReadBytes('\n')
+		if len(raw) == 0 && err != nil {
+			return nil, 0, err
+		}
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		nr.line++
+		if raw = bytes.TrimSpace(raw); len(raw) == 0 {
+			continue
+		}
+		car, err := nr.decode(raw)
+		if err != nil {
+			return nil, nr.line, &services.RowError{Line: nr.line, Err: err}
+		}
+		return car, nr.line, nil
+	}
+}
+
+// decode renames the keys of the object on a line to those of a car before
+// decoding it, so type errors are reported against the car field.
+func (nr *ndjsonReader) decode(raw []byte) (*models.Car, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	renamed := make(map[string]json.RawMessage, len(obj))
+	var errs []services.FieldError
+	for key, value := range obj {
+		col, ok := nr.mapping.resolve(key)
+		switch {
+		case !ok:
+			errs = append(errs, services.FieldError{Field: key, Message: "is not a car field"})
+		case col.set != nil:
+			renamed[col.json] = value
+		}
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return nil, &services.ValidationError{Fields: errs}
+	}
+
+	doc, err := json.Marshal(renamed)
+	if err != nil {
+		return nil, err
+	}
+	var car models.Car
+	if err = json.Unmarshal(doc, &car); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, &services.ValidationError{Fields: []services.FieldError{{
+				Field:   typeErr.Field,
+				Message: "must be of type " + typeErr.Type.String(),
+			}}}
+		}
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return &car, nil
+}
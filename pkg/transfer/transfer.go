@@ -0,0 +1,211 @@
+// Package transfer reads and writes cars as CSV or newline delimited JSON,
+// the formats the inventory is imported from and exported to.
+package transfer
+
+import (
+	"fmt"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/repository"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is a file format cars are transferred in.
+type Format string
+
+const (
+	CSV    Format = "csv"
+	NDJSON Format = "ndjson"
+)
+
+// ContentType returns the media type of f.
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// formats maps the names and media types a format is known by to it.
+var formats = map[string]Format{
+	"csv":                  CSV,
+	"text/csv":             CSV,
+	"ndjson":               NDJSON,
+	"jsonl":                NDJSON,
+	"application/x-ndjson": NDJSON,
+	"application/ndjson":   NDJSON,
+	"application/jsonl":    NDJSON,
+}
+
+// ParseFormat returns the format named by s, a format name such as "csv" or
+// a media type such as "text/csv", parameters allowed.
+func ParseFormat(s string) (Format, bool) {
+	if mt, _, err := mime.ParseMediaType(s); err == nil {
+		s = mt
+	}
+	f, ok := formats[strings.ToLower(strings.TrimSpace(s))]
+	return f, ok
+}
+
+var (
+	// ErrUnknownColumn is returned, wrapped, for a CSV header that is neither
+	// a car field nor mapped to one.
+	ErrUnknownColumn = repository.NewError(repository.KindInvalid, "unknown_column", "unknown column")
+	// ErrMalformed is returned, wrapped, for a header, row or line that is not
+	// valid CSV or JSON.
+	ErrMalformed = repository.NewError(repository.KindInvalid, "malformed_row", "malformed row")
+	// ErrInvalidMapping is returned, wrapped, for a malformed column mapping.
+	ErrInvalidMapping = repository.NewError(repository.KindInvalid, "invalid_mapping", "invalid column mapping")
+)
+
+// Skip is the field a column is mapped to in order to ignore it.
+const Skip = "-"
+
+// field is a car field as it appears in a file.
+type field struct {
+	name string
+	// json is the key of the field in the JSON form of a car.
+	json string
+	get  func(c *models.Car) string
+	// set stores the text of a cell in the car. It is nil for read-only
+	// fields, which are accepted on import and ignored.
+	set func(c *models.Car, v string) error
+}
+
+func textField(name, json string, value func(c *models.Car) *string) field {
+	return field{
+		name: name,
+		json: json,
+		get:  func(c *models.Car) string { return *value(c) },
+		set:  func(c *models.Car, v string) error { *value(c) = v; return nil },
+	}
+}
+
+func intField(name string, value func(c *models.Car) *int) field {
+	return field{
+		name: name,
+		json: name,
+		get:  func(c *models.Car) string { return strconv.Itoa(*value(c)) },
+		set: func(c *models.Car, v string) error {
+			if v == "" {
+				return nil
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("must be an integer")
+			}
+			*value(c) = n
+			return nil
+		},
+	}
+}
+
+// fields lists the car fields in the order they are exported.
+var fields = []field{
+	textField("id", "id", func(c *models.Car) *string { return &c.Id }),
+	textField("make", "make", func(c *models.Car) *string { return &c.Make }),
+	textField("model", "model", func(c *models.Car) *string { return &c.Model }),
+	textField("package", "package", func(c *models.Car) *string { return &c.Package }),
+	textField("color", "color", func(c *models.Car) *string { return &c.Color }),
+	intField("year", func(c *models.Car) *int { return &c.Year }),
+	textField("category", "Category", func(c *models.Car) *string { return &c.Category }),
+	intField("mileage", func(c *models.Car) *int { return &c.Mileage }),
+	intField("price", func(c *models.Car) *int { return &c.Price }),
+	textField("vin", "vin", func(c *models.Car) *string { return &c.VIN }),
+	{
+		name: "version",
+		json: "version",
+		get:  func(c *models.Car) string { return strconv.FormatInt(c.Version, 10) },
+		set: func(c *models.Car, v string) error {
+			if v == "" {
+				return nil
+			}
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("must be an integer")
+			}
+			c.Version = n
+			return nil
+		},
+	},
+	{
+		name: "createdAt",
+		json: "createdAt",
+		get: func(c *models.Car) string {
+			if c.CreatedAt == nil {
+				return ""
+			}
+			return c.CreatedAt.Format(time.RFC3339Nano)
+		},
+	},
+	{
+		name: "deletedAt",
+		json: "deletedAt",
+		get: func(c *models.Car) string {
+			if c.DeletedAt == nil {
+				return ""
+			}
+			return c.DeletedAt.Format(time.RFC3339Nano)
+		},
+	},
+}
+
+// Columns returns the names of the columns of an exported CSV file.
+func Columns() []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	return names
+}
+
+// lookup finds the field a column or key is named after, ignoring case.
+func lookup(name string) (field, bool) {
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) || strings.EqualFold(f.json, name) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// Mapping renames the columns of a CSV file, or the keys of an NDJSON one,
+// to car fields. Names are compared ignoring case, and a column mapped to
+// Skip is ignored.
+type Mapping map[string]string
+
+// ParseMapping parses a comma separated list of column:field pairs, e.g.
+// "Brand:make,Colour:color,Notes:-".
+func ParseMapping(s string) (Mapping, error) {
+	m := Mapping{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, ":")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("%w: %q is not column:field", ErrInvalidMapping, pair)
+		}
+		if _, known := lookup(to); !known && to != Skip {
+			return nil, fmt.Errorf("%w: %q is not a car field", ErrInvalidMapping, to)
+		}
+		m[strings.ToLower(from)] = to
+	}
+	return m, nil
+}
+
+// resolve returns the field a column or key name stands for. ok is false for
+// unknown names, and the field is the zero one for skipped names.
+func (m Mapping) resolve(name string) (f field, ok bool) {
+	name = strings.TrimSpace(name)
+	if to, mapped := m[strings.ToLower(name)]; mapped {
+		if to == Skip {
+			return field{}, true
+		}
+		name = to
+	}
+	return lookup(name)
+}
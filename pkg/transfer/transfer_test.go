@@ -0,0 +1,288 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"github.com/hecomp/cars/internal/models"
+	"github.com/hecomp/cars/pkg/services"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   Format
+		wantOk bool
+	}{
+		{"csv", CSV, true},
+		{" CSV ", CSV, true},
+		{"text/csv; charset=utf-8", CSV, true},
+		{"ndjson", NDJSON, true},
+		{"jsonl", NDJSON, true},
+		{"application/x-ndjson", NDJSON, true},
+		{"application/ndjson", NDJSON, true},
+		{"application/json", "", false},
+		{"xlsx", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := ParseFormat(tt.in); got != tt.want || ok != tt.wantOk {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Mapping
+		wantErr error
+	}{
+		{"", Mapping{}, nil},
+		{"Brand:make, Colour : color,Notes:-,", Mapping{"brand": "make", "colour": "color", "notes": Skip}, nil},
+		{"Kind:Category", Mapping{"kind": "Category"}, nil},
+		{"Brand", nil, ErrInvalidMapping},
+		{"Brand:", nil, ErrInvalidMapping},
+		{":make", nil, ErrInvalidMapping},
+		{"Brand:manufacturer", nil, ErrInvalidMapping},
+	}
+	for _, tt := range tests {
+		got, err := ParseMapping(tt.in)
+		if !errors.Is(err, tt.wantErr) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMapping(%q) = %v, %v, want %v, %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// row is a car or the error read from a line.
+type row struct {
+	line int
+	car  *models.Car
+	// fields are the invalid fields of a row failing validation, and err
+	// the error any other failing row wraps.
+	fields []string
+	err    error
+}
+
+// readAll reads every row of r.
+func readAll(t *testing.T, r services.RowReader) []row {
+	t.Helper()
+	var rows []row
+	for n := 0; n < 100; n++ {
+		car, line, err := r.Next()
+		if err == io.EOF {
+			return rows
+		}
+		var rowErr *services.RowError
+		if err != nil && !errors.As(err, &rowErr) {
+			t.Fatalf("Next = %v, want a row or a *RowError", err)
+		}
+		got := row{line: line, car: car}
+		if rowErr != nil {
+			if rowErr.Line != line {
+				t.Errorf("row error on line %d returned with line %d", rowErr.Line, line)
+			}
+			var verr *services.ValidationError
+			if errors.As(err, &verr) {
+				for _, f := range verr.Fields {
+					got.fields = append(got.fields, f.Field)
+				}
+			} else {
+				got.err = err
+			}
+		}
+		rows = append(rows, got)
+	}
+	t.Fatal("reader does not end")
+	return nil
+}
+
+func checkRows(t *testing.T, got, want []row) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("read %d rows, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.line != w.line || !reflect.DeepEqual(g.car, w.car) || !reflect.DeepEqual(g.fields, w.fields) || !errors.Is(g.err, w.err) {
+			t.Errorf("row %d = line %d %+v %v %v, want line %d %+v %v %v",
+				i, g.line, g.car, g.fields, g.err, w.line, w.car, w.fields, w.err)
+		}
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		mapping string
+		want    []row
+		wantErr error
+	}{
+		{"empty file", "", "", nil, nil},
+		{"header only", "make,model\n", "", nil, nil},
+		{"fields by name", "make,model,year,price,Category,vin\nFord,F150,2019,100, Truck ,1FAFP4040WF100000\n", "", []row{
+			{line: 2, car: &models.Car{Make: "Ford", Model: "F150", Year: 2019, Price: 100, Category: "Truck", VIN: "1FAFP4040WF100000"}},
+		}, nil},
+		{"headers in any case with a byte order mark", "\ufeffMAKE, Model ,CATEGORY\nKia,Rio,Sedan\n", "", []row{
+			{line: 2, car: &models.Car{Make: "Kia", Model: "Rio", Category: "Sedan"}},
+		}, nil},
+		{"mapped and skipped columns", "Brand,Colour,Notes,mileage\nKia,red,one owner,1000\n", "Brand:make,Colour:color,Notes:-", []row{
+			{line: 2, car: &models.Car{Make: "Kia", Color: "red", Mileage: 1000}},
+		}, nil},
+		{"export columns", "id,make,version,createdAt,deletedAt\nabc,Kia,3,2020-01-01T00:00:00Z,\n", "", []row{
+			{line: 2, car: &models.Car{Id: "abc", Make: "Kia", Version: 3}},
+		}, nil},
+		{"empty numbers", "make,year,price\nKia,,\n", "", []row{
+			{line: 2, car: &models.Car{Make: "Kia"}},
+		}, nil},
+		{"numbers that aren't", "make,year,price,version\nKia,new,1e3,x\nFord,2019,1,1\n", "", []row{
+			{line: 2, fields: []string{"year", "price", "version"}},
+			{line: 3, car: &models.Car{Make: "Ford", Year: 2019, Price: 1, Version: 1}},
+		}, nil},
+		{"short and long rows", "make,model\nKia\nFord,F150,extra\nHonda,Civic\n", "", []row{
+			{line: 2, err: ErrMalformed},
+			{line: 3, err: ErrMalformed},
+			{line: 4, car: &models.Car{Make: "Honda", Model: "Civic"}},
+		}, nil},
+		{"malformed quotes", "make,model\nKia,\"Rio\nFord,F150\n", "", []row{
+			{line: 2, err: ErrMalformed},
+		}, nil},
+		{"rows are numbered by their first line", "make,model\n\"Kia\",\"Rio\nLX\"\nFord,F150\n", "", []row{
+			{line: 2, car: &models.Car{Make: "Kia", Model: "Rio\nLX"}},
+			{line: 4, car: &models.Car{Make: "Ford", Model: "F150"}},
+		}, nil},
+
+		{"unknown columns", "make,weight,Brand\nKia,1,2\n", "", nil, ErrUnknownColumn},
+		{"two columns for a field", "make,Brand\nKia,Kia\n", "Brand:make", nil, ErrInvalidMapping},
+		{"malformed header", "make,\"model\n", "", nil, ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMapping(tt.mapping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewReader(CSV, strings.NewReader(tt.in), m)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewReader = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			checkRows(t, readAll(t, r), tt.want)
+		})
+	}
+}
+
+func TestNDJSONReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		mapping string
+		want    []row
+	}{
+		{"empty file", "", "", nil},
+		{"fields by key", `{"make":"Ford","model":"F150","year":2019,"Category":"Truck","vin":"1FAFP4040WF100000"}` + "\n", "", []row{
+			{line: 1, car: &models.Car{Make: "Ford", Model: "F150", Year: 2019, Category: "Truck", VIN: "1FAFP4040WF100000"}},
+		}},
+		{"keys in any case", `{"MAKE":"Kia","category":"Sedan"}`, "", []row{
+			{line: 1, car: &models.Car{Make: "Kia", Category: "Sedan"}},
+		}},
+		{"blank lines and no final newline", "\n" + `{"make":"Kia"}` + "\r\n  \n" + `{"make":"Ford"}`, "", []row{
+			{line: 2, car: &models.Car{Make: "Kia"}},
+			{line: 4, car: &models.Car{Make: "Ford"}},
+		}},
+		{"mapped and skipped keys", `{"brand":"Kia","notes":"one owner","price":5}`, "Brand:make,Notes:-", []row{
+			{line: 1, car: &models.Car{Make: "Kia", Price: 5}},
+		}},
+		{"read-only keys", `{"id":"abc","make":"Kia","version":2,"createdAt":"2020-01-01T00:00:00Z","deletedAt":null}`, "", []row{
+			{line: 1, car: &models.Car{Id: "abc", Make: "Kia", Version: 2}},
+		}},
+		{"unknown keys", `{"weight":1,"make":"Kia","colour":"red"}`, "", []row{
+			{line: 1, fields: []string{"colour", "weight"}},
+		}},
+		{"wrong type", `{"make":"Kia","year":"2019"}` + "\n" + `{"make":"Ford"}`, "", []row{
+			{line: 1, fields: []string{"year"}},
+			{line: 2, car: &models.Car{Make: "Ford"}},
+		}},
+		{"malformed lines", `{"make":"Kia"` + "\n[1]\n" + `{"make":"Ford"}`, "", []row{
+			{line: 1, err: ErrMalformed},
+			{line: 2, err: ErrMalformed},
+			{line: 3, car: &models.Car{Make: "Ford"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMapping(tt.mapping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewReader(NDJSON, strings.NewReader(tt.in), m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRows(t, readAll(t, r), tt.want)
+		})
+	}
+}
+
+func TestCSVWriterHeader(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriter(CSV, &buf).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), strings.Join(Columns(), ",")+"\n"; got != want {
+		t.Errorf("empty export = %q, want %q", got, want)
+	}
+}
+
+// TestRoundTrip exports cars and imports them back: every field an import
+// sets must come back as it was exported.
+func TestRoundTrip(t *testing.T) {
+	created := time.Date(2023, 3, 1, 12, 30, 0, 5, time.UTC)
+	cars := []*models.Car{
+		{Id: "a", Make: "Ford", Model: "F-150, \"Raptor\"", Package: "Base", Color: "Red", Year: 2019,
+			Category: "Truck", Mileage: 1000, Price: 45000, VIN: "1FAFP4040WF100000", Version: 3, CreatedAt: &created},
+		{Id: "b", Make: "Kia", Model: "Rio\nLX", Version: 1, CreatedAt: &created, DeletedAt: &created},
+		{Id: "c", Make: "Citroën", Model: " spaced "},
+	}
+	for _, format := range []Format{CSV, NDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(format, &buf)
+			for _, car := range cars {
+				if err := w.Write(car); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewReader(format, &buf, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows := readAll(t, r)
+			if len(rows) != len(cars) {
+				t.Fatalf("read %d rows, want %d", len(rows), len(cars))
+			}
+			for i, car := range cars {
+				want := *car
+				want.CreatedAt, want.DeletedAt = nil, nil
+				if format == CSV {
+					// Cells are trimmed on import.
+					want.Model = strings.TrimSpace(want.Model)
+				}
+				if got := rows[i].car; got == nil || !reflect.DeepEqual(*got, want) {
+					t.Errorf("row %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
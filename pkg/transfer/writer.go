@@ -0,0 +1,68 @@
+package transfer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/hecomp/cars/internal/models"
+	"io"
+)
+
+// Writer writes cars to a file as they come.
+type Writer interface {
+	Write(car *models.Car) error
+	// Flush writes out anything buffered. A CSV file gets its header even
+	// when no car was written.
+	Flush() error
+}
+
+// NewWriter returns a writer of cars to w in format f.
+func NewWriter(f Format, w io.Writer) Writer {
+	if f == NDJSON {
+		return ndjsonWriter{enc: json.NewEncoder(w)}
+	}
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (cw *csvWriter) writeHeader() error {
+	if cw.header {
+		return nil
+	}
+	cw.header = true
+	return cw.w.Write(Columns())
+}
+
+func (cw *csvWriter) Write(car *models.Car) error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	record := make([]string, len(fields))
+	for i, f := range fields {
+		record[i] = f.get(car)
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) Flush() error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (nw ndjsonWriter) Write(car *models.Car) error {
+	return nw.enc.Encode(car)
+}
+
+func (nw ndjsonWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,200 @@
+// Package vin validates and decodes 17 character vehicle identification
+// numbers as laid out by ISO 3779.
+package vin
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Length is the number of characters in a VIN.
+const Length = 17
+
+var (
+	// ErrLength is returned, wrapped, when a VIN is not 17 characters long.
+	ErrLength = errors.New("vin must be 17 characters")
+	// ErrCharacter is returned, wrapped, when a VIN contains a character
+	// other than a digit or a capital letter other than I, O and Q.
+	ErrCharacter = errors.New("vin contains an invalid character")
+	// ErrCheckDigit is returned, wrapped, when the ninth character does not
+	// match the check digit computed from the others.
+	ErrCheckDigit = errors.New("vin check digit mismatch")
+)
+
+// weights are the position weights of the check digit calculation. The
+// check digit itself, at position 9, has no weight.
+var weights = [Length]int{8, 7, 6, 5, 4, 3, 2, 10, 0, 9, 8, 7, 6, 5, 4, 3, 2}
+
+// yearCodes lists the model year characters, at position 10, in the order of
+// the 30 year cycle starting in 1980.
+const yearCodes = "ABCDEFGHJKLMNPRSTVWXY123456789"
+
+const firstCycle = 1980
+
+//go:embed wmi.csv
+var wmiCSV string
+
+// Manufacturer describes the maker a world manufacturer identifier is
+// assigned to.
+type Manufacturer struct {
+	Name string
+	// Makes are the brands sold under the identifier.
+	Makes []string
+}
+
+var manufacturers = loadWMI(wmiCSV)
+
+// knownMakes holds the lower cased makes of every manufacturer in the table.
+var knownMakes = func() map[string]bool {
+	makes := make(map[string]bool)
+	for _, m := range manufacturers {
+		for _, name := range m.Makes {
+			makes[strings.ToLower(name)] = true
+		}
+	}
+	return makes
+}()
+
+// KnownMake reports whether make is sold by a manufacturer of the embedded
+// WMI table, ignoring case and surrounding space.
+func KnownMake(make string) bool {
+	return knownMakes[strings.ToLower(strings.TrimSpace(make))]
+}
+
+func loadWMI(data string) map[string]Manufacturer {
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		panic(fmt.Sprintf("vin: invalid embedded WMI table: %v", err))
+	}
+	table := make(map[string]Manufacturer, len(records))
+	for _, rec := range records[1:] {
+		table[rec[0]] = Manufacturer{Name: rec[1], Makes: strings.Split(rec[2], ";")}
+	}
+	return table
+}
+
+// Info is what can be read from a VIN without an online lookup.
+type Info struct {
+	VIN string
+	// WMI is the world manufacturer identifier, the first three characters.
+	WMI string
+	// Manufacturer is nil when the WMI is not in the embedded table.
+	Manufacturer *Manufacturer
+	// ModelYears are the model years the tenth character may stand for,
+	// oldest first. The code repeats every 30 years; for North American
+	// vehicles the seventh character tells the cycles apart.
+	ModelYears []int
+}
+
+// MatchesMake reports whether make is one of the makes of the decoded
+// manufacturer. It is true when the manufacturer is unknown.
+func (i Info) MatchesMake(make string) bool {
+	if i.Manufacturer == nil {
+		return true
+	}
+	for _, m := range i.Manufacturer.Makes {
+		if strings.EqualFold(m, strings.TrimSpace(make)) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesYear reports whether year is one of the decoded model years.
+func (i Info) MatchesYear(year int) bool {
+	for _, y := range i.ModelYears {
+		if y == year {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize trims s and upper cases it.
+func Normalize(s string) string {
+	return strings.ToUpper(strings.TrimSpace(s))
+}
+
+// Validate checks the length, alphabet and check digit of a normalized VIN.
+func Validate(v string) error {
+	if len(v) != Length {
+		return fmt.Errorf("%w: got %d", ErrLength, len(v))
+	}
+	sum := 0
+	for i := 0; i < Length; i++ {
+		n, ok := transliterate(v[i])
+		if !ok {
+			return fmt.Errorf("%w %q at position %d", ErrCharacter, v[i], i+1)
+		}
+		sum += n * weights[i]
+	}
+	want := byte('0' + sum%11)
+	if sum%11 == 10 {
+		want = 'X'
+	}
+	if v[8] != want {
+		return fmt.Errorf("%w: expected %q at position 9", ErrCheckDigit, want)
+	}
+	return nil
+}
+
+// Decode validates a normalized VIN and decodes its manufacturer and model
+// year. Model years later than maxYear are left out.
+func Decode(v string, maxYear int) (Info, error) {
+	if err := Validate(v); err != nil {
+		return Info{}, err
+	}
+	info := Info{VIN: v, WMI: v[:3]}
+	if m, ok := manufacturers[info.WMI]; ok {
+		info.Manufacturer = &m
+	}
+
+	pos := strings.IndexByte(yearCodes, v[9])
+	if pos < 0 {
+		return info, nil
+	}
+	for year := firstCycle + pos; year <= maxYear; year += len(yearCodes) {
+		if northAmerican(v) && !inCycle(v, year) {
+			continue
+		}
+		info.ModelYears = append(info.ModelYears, year)
+	}
+	return info, nil
+}
+
+// inCycle reports whether year is in the cycle the seventh character of a
+// North American VIN marks: a digit marks 1980-2009 and a letter the years
+// after. A letter with no year after 2009 to stand for leaves no model year.
+func inCycle(v string, year int) bool {
+	digit := v[6] >= '0' && v[6] <= '9'
+	return digit == (year < firstCycle+len(yearCodes))
+}
+
+// northAmerican reports whether the VIN was assigned in the United States,
+// Canada or Mexico.
+func northAmerican(v string) bool {
+	return v[0] >= '1' && v[0] <= '5'
+}
+
+// transliterate returns the value of a VIN character in the check digit
+// calculation.
+func transliterate(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'A' && c <= 'H':
+		return int(c-'A') + 1, true
+	case c >= 'J' && c <= 'N':
+		return int(c-'J') + 1, true
+	case c == 'P':
+		return 7, true
+	case c == 'R':
+		return 9, true
+	case c >= 'S' && c <= 'Z':
+		return int(c-'S') + 2, true
+	}
+	return 0, false
+}
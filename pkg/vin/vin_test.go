@@ -0,0 +1,115 @@
+package vin
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		vin     string
+		wantErr error
+	}{
+		{"1HGCM82633A004352", nil},
+		{"1M8GDM9AXKP042788", nil},
+		{"11111111111111111", nil},
+		{"WVWZZZ1J9YW000001", nil},
+		{"1HGCM82643A004352", ErrCheckDigit},
+		{"1M8GDM9A0KP042788", ErrCheckDigit},
+		{"1HGCM82633A00435", ErrLength},
+		{"1HGCM82633A0043521", ErrLength},
+		{"", ErrLength},
+		{"1HGCM82633I004352", ErrCharacter},
+		{"1HGCM82633O004352", ErrCharacter},
+		{"1HGCM82633Q004352", ErrCharacter},
+		{"1hgcm82633a004352", ErrCharacter},
+		{"1HGCM8263-A004352", ErrCharacter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.vin, func(t *testing.T) {
+			if err := Validate(tt.vin); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name             string
+		vin              string
+		maxYear          int
+		wantManufacturer string
+		wantYears        []int
+	}{
+		{"north american, digit in position 7", "1HGCM82633A004352", 2026, "Honda", []int{2003}},
+		{"north american, 1980s cycle", "1FAFP4400AF100000", 2026, "Ford", []int{1980}},
+		{"north american, 2010s cycle", "1FAFP4A05AF100000", 2026, "Ford", []int{2010}},
+		{"north american, letter in position 7", "1FAFP4A02HF100000", 2026, "Ford", []int{2017}},
+		{"north american, later cycle not reached", "1FAFP4040WF100000", 2026, "Ford", []int{1998}},
+		{"north american, cycle after max year", "1FAFP4A05AF100000", 2005, "Ford", nil},
+		{"north american, digit before max year", "1FAFP4400AF100000", 2005, "Ford", []int{1980}},
+		{"european, every cycle", "WVWZZZ1J1AW000001", 2026, "Volkswagen", []int{1980, 2010}},
+		{"european, cycles after max year", "WVWZZZ1J1AW000001", 2009, "Volkswagen", []int{1980}},
+		{"european, single cycle", "WVWZZZ1J9YW000001", 2026, "Volkswagen", []int{2000}},
+		{"unknown manufacturer, no year code", "ZZZZZZZZ9ZZZZZZZZ", 2026, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Decode(tt.vin, tt.maxYear)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.WMI != tt.vin[:3] {
+				t.Errorf("WMI = %q", info.WMI)
+			}
+			var manufacturer string
+			if info.Manufacturer != nil {
+				manufacturer = info.Manufacturer.Name
+			}
+			if manufacturer != tt.wantManufacturer {
+				t.Errorf("manufacturer = %q, want %q", manufacturer, tt.wantManufacturer)
+			}
+			if !reflect.DeepEqual(info.ModelYears, tt.wantYears) {
+				t.Errorf("model years = %v, want %v", info.ModelYears, tt.wantYears)
+			}
+			for _, year := range tt.wantYears {
+				if !info.MatchesYear(year) {
+					t.Errorf("does not match %d", year)
+				}
+			}
+			if info.MatchesYear(tt.maxYear + 1) {
+				t.Errorf("matches %d, after the max year", tt.maxYear+1)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsInvalid(t *testing.T) {
+	if _, err := Decode("1HGCM82643A004352", 2026); !errors.Is(err, ErrCheckDigit) {
+		t.Errorf("Decode = %v, want %v", err, ErrCheckDigit)
+	}
+}
+
+func TestMatchesMake(t *testing.T) {
+	tests := []struct {
+		vin  string
+		make string
+		want bool
+	}{
+		{"1HGCM82633A004352", "Honda", true},
+		{"1HGCM82633A004352", " honda ", true},
+		{"1HGCM82633A004352", "Ford", false},
+		{"ZZZZZZZZ9ZZZZZZZZ", "Anything", true},
+	}
+	for _, tt := range tests {
+		info, err := Decode(tt.vin, 2026)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.MatchesMake(tt.make); got != tt.want {
+			t.Errorf("%v matches %q = %v, want %v", tt.vin, tt.make, got, tt.want)
+		}
+	}
+}